@@ -0,0 +1,438 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/cploutarchou/go-kafka-rest/types"
+	"github.com/cploutarchou/go-kafka-rest/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// AdminController serves the cluster administration endpoints, they are
+// admin only. Every change made through them is recorded in the audit trail.
+type AdminController struct {
+	DB    *gorm.DB
+	Admin *kafka.Admin
+}
+
+func NewAdminController(db *gorm.DB, admin *kafka.Admin) AdminController {
+	return AdminController{DB: db, Admin: admin}
+}
+
+// adminErrorStatus maps an error of the cluster admin to the HTTP status reported to the caller
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sarama.ErrTopicAlreadyExists):
+		return fiber.StatusConflict
+	case errors.Is(err, sarama.ErrInvalidPartitions),
+		errors.Is(err, sarama.ErrInvalidReplicationFactor),
+		errors.Is(err, sarama.ErrInvalidReplicaAssignment),
+		errors.Is(err, sarama.ErrInvalidConfig),
+		errors.Is(err, sarama.ErrPolicyViolation):
+		return fiber.StatusBadRequest
+	case errors.Is(err, sarama.ErrTopicDeletionDisabled):
+		return fiber.StatusForbidden
+	default:
+		return produceErrorStatus(err)
+	}
+}
+
+func (ac *AdminController) ListTopics(c *fiber.Ctx) error {
+	topics, err := ac.Admin.ListTopics()
+	if err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	data := make([]types.Topic, len(topics))
+	for i, topic := range topics {
+		data[i] = types.Topic{
+			Name:              topic.Name,
+			Partitions:        topic.Partitions,
+			ReplicationFactor: topic.ReplicationFactor,
+			Configs:           topic.Configs,
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"topics": data}})
+}
+
+func (ac *AdminController) DescribeTopic(c *fiber.Ctx) error {
+	topic, err := ac.Admin.DescribeTopic(c.Params("topic"))
+	if err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	description := types.TopicDescription{
+		Name:              topic.Name,
+		Internal:          topic.Internal,
+		ReplicationFactor: topic.ReplicationFactor,
+		Partitions:        make([]types.PartitionDescription, len(topic.Partitions)),
+	}
+	for i, partition := range topic.Partitions {
+		description.Partitions[i] = types.PartitionDescription{
+			Partition:       partition.Partition,
+			Leader:          partition.Leader,
+			Replicas:        partition.Replicas,
+			ISR:             partition.ISR,
+			OfflineReplicas: partition.OfflineReplicas,
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": description})
+}
+
+func (ac *AdminController) CreateTopic(c *fiber.Ctx) error {
+	var payload types.CreateTopicPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+	for name, value := range payload.Configs {
+		if err := kafka.ValidateTopicConfig(name, value); err != nil {
+			return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+		}
+	}
+
+	spec, err := ac.Admin.CreateTopic(kafka.TopicSpec{
+		Name:              payload.Name,
+		Partitions:        payload.Partitions,
+		ReplicationFactor: payload.ReplicationFactor,
+		Configs:           payload.Configs,
+	})
+	if err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	ac.audit(c, models.AuditCreateTopic, spec.Name, map[string]interface{}{
+		"partitions":         spec.Partitions,
+		"replication_factor": spec.ReplicationFactor,
+		"configs":            spec.Configs,
+	})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "data": types.Topic{
+		Name:              spec.Name,
+		Partitions:        spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		Configs:           spec.Configs,
+	}})
+}
+
+func (ac *AdminController) DeleteTopic(c *fiber.Ctx) error {
+	topic := c.Params("topic")
+	if err := ac.Admin.DeleteTopic(topic); err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	ac.audit(c, models.AuditDeleteTopic, topic, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Topic deleted"})
+}
+
+func (ac *AdminController) DescribeTopicConfig(c *fiber.Ctx) error {
+	configs, err := ac.Admin.DescribeTopicConfig(c.Params("topic"))
+	if err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	data := make([]types.TopicConfig, len(configs))
+	for i, config := range configs {
+		data[i] = types.TopicConfig{
+			Name:      config.Name,
+			Value:     config.Value,
+			Source:    config.Source,
+			Default:   config.Default,
+			ReadOnly:  config.ReadOnly,
+			Sensitive: config.Sensitive,
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"configs": data}})
+}
+
+// AlterTopicConfig changes the configs named in the request and leaves the
+// others alone. The reply lists every config before and after; with the
+// dry_run query parameter the broker only validates the change.
+func (ac *AdminController) AlterTopicConfig(c *fiber.Ctx) error {
+	topic := c.Params("topic")
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	var payload types.AlterTopicConfigPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	if len(payload.Set) == 0 && len(payload.Delete) == 0 {
+		return utils.RespondError(c, fiber.StatusBadRequest, "Nothing to change, set or delete configs")
+	}
+
+	changes := make(map[string]*string, len(payload.Set)+len(payload.Delete))
+	for name, value := range payload.Set {
+		if err := kafka.ValidateTopicConfig(name, value); err != nil {
+			return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+		}
+		value := value
+		changes[name] = &value
+	}
+	for _, name := range payload.Delete {
+		if !kafka.IsTopicConfig(name) {
+			return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprintf("unknown topic config %q", name))
+		}
+		if _, ok := changes[name]; ok {
+			return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprintf("%s is both set and deleted", name))
+		}
+		changes[name] = nil
+	}
+
+	configs, err := ac.Admin.DescribeTopicConfig(topic)
+	if err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	current := make(map[string]kafka.ConfigEntry, len(configs))
+	for _, config := range configs {
+		current[config.Name] = config
+	}
+	diff := make([]types.ConfigChange, 0, len(changes))
+	for name, value := range changes {
+		if current[name].ReadOnly {
+			return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprintf("%s is read-only", name))
+		}
+		diff = append(diff, types.ConfigChange{Name: name, Old: current[name].Value, New: value})
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i].Name < diff[j].Name })
+
+	if err := ac.Admin.AlterTopicConfig(topic, changes, dryRun); err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	if !dryRun {
+		ac.audit(c, models.AuditAlterTopicConfig, topic, map[string]interface{}{"changes": diff})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"topic":   topic,
+		"dry_run": dryRun,
+		"changes": diff,
+	}})
+}
+
+// CreatePartitions raises the partition count of a topic. Keys hash to other
+// partitions afterwards, so the dry_run query parameter lets callers check
+// the request first.
+func (ac *AdminController) CreatePartitions(c *fiber.Ctx) error {
+	topic := c.Params("topic")
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	var payload types.CreatePartitionsPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	description, err := ac.Admin.DescribeTopic(topic)
+	if err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	partitions := int32(len(description.Partitions))
+	if payload.Count <= partitions {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprintf("topic %s has %d partitions, count must be higher", topic, partitions))
+	}
+
+	if err := ac.Admin.CreatePartitions(topic, payload.Count, dryRun); err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	if !dryRun {
+		ac.audit(c, models.AuditCreatePartitions, topic, map[string]interface{}{"from": partitions, "to": payload.Count})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"topic":   topic,
+		"dry_run": dryRun,
+		"from":    partitions,
+		"to":      payload.Count,
+	}})
+}
+
+// ResetGroupOffsets moves the committed offsets of a consumer group, like
+// kafka-consumer-groups --reset-offsets. A request previews the reset unless
+// it executes it with the token of its preview, and groups with active
+// members are refused.
+func (ac *AdminController) ResetGroupOffsets(c *fiber.Ctx) error {
+	group := c.Params("group")
+
+	var payload types.OffsetResetPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	reset := kafka.OffsetReset{Mode: payload.Mode, Topics: payload.Topics, Partitions: toTopicPartitions(payload.Partitions)}
+	switch {
+	case len(payload.Topics) == 0 && len(payload.Partitions) == 0:
+		return utils.RespondError(c, fiber.StatusBadRequest, "Name the topics or partitions to reset")
+	case payload.Mode == kafka.ResetToDatetime && payload.Datetime == nil:
+		return utils.RespondError(c, fiber.StatusBadRequest, "to-datetime needs a datetime")
+	case payload.Mode == kafka.ResetShiftBy && payload.Shift == nil:
+		return utils.RespondError(c, fiber.StatusBadRequest, "shift-by needs a shift")
+	case payload.Mode == kafka.ResetToExplicitOffset && payload.Offset == nil:
+		return utils.RespondError(c, fiber.StatusBadRequest, "to-explicit-offset needs an offset")
+	}
+	if payload.Datetime != nil {
+		reset.Datetime = *payload.Datetime
+	}
+	if payload.Shift != nil {
+		reset.Shift = *payload.Shift
+	}
+	if payload.Offset != nil {
+		reset.Offset = *payload.Offset
+	}
+
+	var plan *kafka.OffsetResetPlan
+	var err error
+	if payload.Execute {
+		plan, err = ac.Admin.ResetOffsets(group, reset, payload.Token)
+	} else {
+		plan, err = ac.Admin.PreviewOffsetReset(group, reset)
+	}
+	if err != nil {
+		return respondResetError(c, err)
+	}
+
+	data := types.OffsetResetPlan{
+		Group:         plan.Group,
+		Mode:          plan.Mode,
+		Token:         plan.Token,
+		ActiveMembers: plan.ActiveMembers,
+		Executed:      payload.Execute,
+		Partitions:    make([]types.PartitionReset, len(plan.Partitions)),
+	}
+	for i, partition := range plan.Partitions {
+		data.Partitions[i] = types.PartitionReset{
+			Topic:     partition.Topic,
+			Partition: partition.Partition,
+			Current:   partition.Current,
+			Target:    partition.Target,
+		}
+	}
+	if payload.Execute {
+		ac.audit(c, models.AuditResetOffsets, group, map[string]interface{}{"mode": plan.Mode, "partitions": data.Partitions})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": data})
+}
+
+func respondResetError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, kafka.ErrGroupActive),
+		errors.Is(err, kafka.ErrResetStale):
+		return utils.RespondError(c, fiber.StatusConflict, err.Error())
+	case errors.Is(err, kafka.ErrResetNotPreviewed),
+		errors.Is(err, kafka.ErrInvalidOffsetReset):
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	default:
+		return respondGroupError(c, err)
+	}
+}
+
+// DescribeCluster lists the brokers, the controller and the partitions that
+// are under-replicated or offline
+func (ac *AdminController) DescribeCluster(c *fiber.Ctx) error {
+	cluster, err := ac.Admin.DescribeCluster()
+	if err != nil {
+		return utils.RespondError(c, adminErrorStatus(err), err.Error())
+	}
+	data := types.ClusterInfo{
+		ControllerID:    cluster.ControllerID,
+		Brokers:         make([]types.Broker, len(cluster.Brokers)),
+		Topics:          cluster.Topics,
+		Partitions:      cluster.Partitions,
+		UnderReplicated: toPartitionStates(cluster.UnderReplicated),
+		Offline:         toPartitionStates(cluster.Offline),
+	}
+	for i, broker := range cluster.Brokers {
+		data.Brokers[i] = types.Broker{
+			ID:         broker.ID,
+			Addr:       broker.Addr,
+			Rack:       broker.Rack,
+			Controller: broker.ID == cluster.ControllerID,
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": data})
+}
+
+func toPartitionStates(states []kafka.PartitionState) []types.PartitionState {
+	result := make([]types.PartitionState, len(states))
+	for i, state := range states {
+		result[i] = types.PartitionState{Topic: state.Topic, PartitionDescription: types.PartitionDescription{
+			Partition:       state.Partition,
+			Leader:          state.Leader,
+			Replicas:        state.Replicas,
+			ISR:             state.ISR,
+			OfflineReplicas: state.OfflineReplicas,
+		}}
+	}
+	return result
+}
+
+// ListAuditEntries lists the changes made through the admin endpoints newest
+// first. The action and resource query parameters filter them, limit and
+// offset page through them.
+func (ac *AdminController) ListAuditEntries(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", defaultAuditLimit)
+	if limit <= 0 {
+		return utils.RespondError(c, fiber.StatusBadRequest, "limit must be a positive number")
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		return utils.RespondError(c, fiber.StatusBadRequest, "offset must not be negative")
+	}
+
+	entries, total, err := models.ListAuditEntries(ac.DB, models.AuditFilter{Action: c.Query("action"), Resource: c.Query("resource")}, limit, offset)
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusInternalServerError, "Failed to list audit entries")
+	}
+	data := make([]types.AuditEntry, len(entries))
+	for i, entry := range entries {
+		data[i] = types.AuditEntry{
+			ID:        entry.ID.String(),
+			UserID:    entry.UserID.String(),
+			UserEmail: entry.UserEmail,
+			Action:    entry.Action,
+			Resource:  entry.Resource,
+			Details:   entry.Details,
+			CreatedAt: entry.CreatedAt,
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"entries": data,
+		"total":   total,
+	}})
+}
+
+// audit records a change made by the user of the request. The change is
+// done by then, so a failure to record it is only logged.
+func (ac *AdminController) audit(c *fiber.Ctx, action string, resource string, details map[string]interface{}) {
+	user := c.Locals("user").(models.UserResponse)
+	entry := &models.AuditEntry{
+		UserID:    user.ID,
+		UserEmail: user.Email,
+		Action:    action,
+		Resource:  resource,
+		Details:   details,
+	}
+	if err := models.CreateAuditEntry(ac.DB, entry); err != nil {
+		log.Printf("failed to audit %s of %s by %s: %v", action, resource, user.Email, err)
+	}
+}
@@ -0,0 +1,365 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/cploutarchou/go-kafka-rest/types"
+	"github.com/cploutarchou/go-kafka-rest/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPollTimeout = time.Second
+	maxPollTimeout     = 30 * time.Second
+	defaultMaxRecords  = 100
+	maxRecordsLimit    = 1000
+)
+
+type ConsumerController struct {
+	Consumer *kafka.Consumer
+}
+
+func NewConsumerController(consumer *kafka.Consumer) ConsumerController {
+	return ConsumerController{Consumer: consumer}
+}
+
+func (cc *ConsumerController) CreateInstance(c *fiber.Ctx) error {
+	var payload types.CreateConsumerPayload
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+		}
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	user := c.Locals("user").(models.UserResponse)
+	group := c.Params("group")
+	opts := kafka.InstanceOptions{
+		FromBeginning: payload.AutoOffsetReset == "earliest",
+		AutoCommit:    payload.AutoCommit == nil || *payload.AutoCommit,
+	}
+	instance, err := cc.Consumer.CreateInstance(group, user.ID.String(), opts)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"instance_id": instance.ID,
+		"base_uri":    fmt.Sprintf("%s/instances/%s", strings.TrimSuffix(c.Path(), "/"), instance.ID),
+	}})
+}
+
+func (cc *ConsumerController) DeleteInstance(c *fiber.Ctx) error {
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	if err := cc.Consumer.DeleteInstance(instance.ID, instance.Owner); err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Consumer instance deleted"})
+}
+
+func (cc *ConsumerController) Subscribe(c *fiber.Ctx) error {
+	var payload types.SubscriptionPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	if err := instance.Subscribe(payload.Topics); err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"topics": instance.Subscription()}})
+}
+
+func (cc *ConsumerController) GetSubscription(c *fiber.Ctx) error {
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"topics": instance.Subscription()}})
+}
+
+func (cc *ConsumerController) Unsubscribe(c *fiber.Ctx) error {
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	instance.Unsubscribe()
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Consumer instance unsubscribed"})
+}
+
+// FetchRecords long-polls the consumer instance. The timeout query parameter is in milliseconds.
+func (cc *ConsumerController) FetchRecords(c *fiber.Ctx) error {
+	timeout := defaultPollTimeout
+	if ms := c.QueryInt("timeout", -1); ms >= 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	if timeout > maxPollTimeout {
+		timeout = maxPollTimeout
+	}
+
+	maxRecords := c.QueryInt("max_records", defaultMaxRecords)
+	if maxRecords <= 0 {
+		return utils.RespondError(c, fiber.StatusBadRequest, "max_records must be a positive number")
+	}
+	if maxRecords > maxRecordsLimit {
+		maxRecords = maxRecordsLimit
+	}
+
+	keyFormat, valueFormat, err := recordFormats(c)
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	records, err := instance.Poll(timeout, maxRecords)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	response := make([]types.ConsumerRecord, 0, len(records))
+	for _, record := range records {
+		response = append(response, toConsumerRecord(record, keyFormat, valueFormat))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"records": response}})
+}
+
+// SetAutoCommit turns auto-commit of the consumer instance on or off
+func (cc *ConsumerController) SetAutoCommit(c *fiber.Ctx) error {
+	var payload types.AutoCommitPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	instance.SetAutoCommit(*payload.Enabled)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"auto_commit": instance.AutoCommit()}})
+}
+
+// CommitOffsets commits the offsets in the body, or everything fetched so far when the body is empty.
+// Offsets are the next ones to read, i.e. the offset of the last processed record plus one.
+func (cc *ConsumerController) CommitOffsets(c *fiber.Ctx) error {
+	var payload types.OffsetsPayload
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+		}
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	committed, err := instance.Commit(toPartitionOffsets(payload.Offsets))
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"offsets": fromPartitionOffsets(committed)}})
+}
+
+// GetCommittedOffsets returns the committed offsets of the partitions assigned to the consumer instance
+func (cc *ConsumerController) GetCommittedOffsets(c *fiber.Ctx) error {
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	committed, err := instance.Committed()
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"offsets": fromPartitionOffsets(committed)}})
+}
+
+// Seek moves partitions of the consumer instance to absolute offsets
+func (cc *ConsumerController) Seek(c *fiber.Ctx) error {
+	var payload types.OffsetsPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+	if len(payload.Offsets) == 0 {
+		return utils.RespondError(c, fiber.StatusBadRequest, "Offsets are missing")
+	}
+
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	if err := instance.Seek(toPartitionOffsets(payload.Offsets)); err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Consumer instance positions updated"})
+}
+
+// SeekToBeginning moves the partitions in the body, or all assigned partitions, to the oldest offset
+func (cc *ConsumerController) SeekToBeginning(c *fiber.Ctx) error {
+	return cc.seekPartitions(c, func(instance *kafka.ConsumerInstance, partitions []kafka.TopicPartition) error {
+		return instance.SeekToBeginning(partitions)
+	})
+}
+
+// SeekToEnd moves the partitions in the body, or all assigned partitions, past the newest offset
+func (cc *ConsumerController) SeekToEnd(c *fiber.Ctx) error {
+	return cc.seekPartitions(c, func(instance *kafka.ConsumerInstance, partitions []kafka.TopicPartition) error {
+		return instance.SeekToEnd(partitions)
+	})
+}
+
+// SeekToTimestamp moves the partitions in the body, or all assigned partitions, to the first
+// record at or after the timestamp
+func (cc *ConsumerController) SeekToTimestamp(c *fiber.Ctx) error {
+	var payload types.SeekTimestampPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	if err := instance.SeekToTimestamp(toTopicPartitions(payload.Partitions), payload.Timestamp); err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Consumer instance positions updated"})
+}
+
+func (cc *ConsumerController) seekPartitions(c *fiber.Ctx, seek func(*kafka.ConsumerInstance, []kafka.TopicPartition) error) error {
+	var payload types.PartitionsPayload
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+		}
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	instance, err := cc.instance(c)
+	if err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	if err := seek(instance, toTopicPartitions(payload.Partitions)); err != nil {
+		return respondConsumerError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Consumer instance positions updated"})
+}
+
+// instance looks up the consumer instance addressed by the route for the logged-in user
+func (cc *ConsumerController) instance(c *fiber.Ctx) (*kafka.ConsumerInstance, error) {
+	user := c.Locals("user").(models.UserResponse)
+	instance, err := cc.Consumer.Instance(c.Params("instance"), user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	if instance.Group != c.Params("group") {
+		return nil, kafka.ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+func respondConsumerError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, kafka.ErrInstanceNotFound):
+		return utils.RespondError(c, fiber.StatusNotFound, err.Error())
+	case errors.Is(err, kafka.ErrInstanceClosed):
+		return utils.RespondError(c, fiber.StatusGone, err.Error())
+	case errors.Is(err, kafka.ErrNotSubscribed):
+		return utils.RespondError(c, fiber.StatusConflict, err.Error())
+	case errors.Is(err, kafka.ErrPartitionNotAssigned),
+		errors.Is(err, kafka.ErrGroupHasInstance):
+		return utils.RespondError(c, fiber.StatusConflict, err.Error())
+	case errors.Is(err, sarama.ErrUnknownTopicOrPartition):
+		return utils.RespondError(c, fiber.StatusNotFound, err.Error())
+	default:
+		return utils.RespondError(c, fiber.StatusBadGateway, err.Error())
+	}
+}
+
+func toTopicPartitions(partitions []types.TopicPartition) []kafka.TopicPartition {
+	result := make([]kafka.TopicPartition, 0, len(partitions))
+	for _, tp := range partitions {
+		result = append(result, kafka.TopicPartition{Topic: tp.Topic, Partition: tp.Partition})
+	}
+	return result
+}
+
+func toPartitionOffsets(offsets []types.PartitionOffset) []kafka.PartitionOffset {
+	result := make([]kafka.PartitionOffset, 0, len(offsets))
+	for _, offset := range offsets {
+		result = append(result, kafka.PartitionOffset{Topic: offset.Topic, Partition: offset.Partition, Offset: offset.Offset})
+	}
+	return result
+}
+
+func fromPartitionOffsets(offsets []kafka.PartitionOffset) []types.PartitionOffset {
+	result := make([]types.PartitionOffset, 0, len(offsets))
+	for _, offset := range offsets {
+		result = append(result, types.PartitionOffset{Topic: offset.Topic, Partition: offset.Partition, Offset: offset.Offset})
+	}
+	return result
+}
@@ -2,9 +2,9 @@ package controllers
 
 import (
 	"github.com/Shopify/sarama"
+	"github.com/cploutarchou/go-kafka-rest/deadletter"
 	"github.com/cploutarchou/go-kafka-rest/kafka"
 	"github.com/cploutarchou/go-kafka-rest/models"
-	"github.com/cploutarchou/go-kafka-rest/types"
 	"gorm.io/gorm"
 	"sync"
 )
@@ -12,28 +12,38 @@ import (
 var (
 	workerPoolSize = 100 // Number of workers in the pool
 	workerPool     = make(chan struct{}, workerPoolSize)
-	wg             sync.WaitGroup         // WaitGroup to wait for workers to finish
-	mutex          sync.Mutex             // Mutex to protect shared resources
-	messageQueue   []types.MessagePayload // Shared message queue
-	producer       *kafka.Producer        // Kafka producer
-	brokers        []string               // Kafka brokers
+	wg             sync.WaitGroup  // WaitGroup to wait for workers to finish
+	mutex          sync.Mutex      // Mutex to protect shared resources
+	messageQueue   []kafka.Message // Shared message queue
+	producer       *kafka.Producer // Kafka producer
+	brokers        []string        // Kafka brokers
+
+	// transactionalProducer serves transactional batches, nil unless enabled
+	transactionalProducer *kafka.TransactionalProducer
 )
 
 type Controller struct {
 	Auth            AuthController
 	User            UserController
+	Consumer        ConsumerController
+	Stream          StreamController
+	DeadLetters     DeadLetterController
+	Admin           AdminController
+	Groups          GroupController
+	Health          HealthController
 	workerPoolSize  int
 	workPool        chan struct{}
 	wg              *sync.WaitGroup
 	mutex           *sync.Mutex
-	messageQueue    []types.MessagePayload
+	messageQueue    []kafka.Message
 	producer        kafka.Producer
 	SaramaConfig    *sarama.Config
 	DB              *gorm.DB
 	totalPartitions int32
 }
 
-func NewController(db *gorm.DB, brokers_ []string, partitions int32) *Controller {
+// NewController creates the controllers, saramaConfig configures the producer and may be nil
+func NewController(db *gorm.DB, brokers_ []string, partitions int32, saramaConfig *sarama.Config) *Controller {
 
 	con := &Controller{
 		User:            NewUserController(db),
@@ -43,9 +53,12 @@ func NewController(db *gorm.DB, brokers_ []string, partitions int32) *Controller
 		mutex:           &mutex,
 		messageQueue:    messageQueue,
 		totalPartitions: partitions,
+		SaramaConfig:    saramaConfig,
 	}
 	brokers = brokers_
 	con.Initialize()
+	con.Consumer = NewConsumerController(kafka.NewConsumer(brokers, nil, kafka.TheConsumerFactory))
+	con.Stream = NewStreamController(brokers, kafka.TheConsumerFactory)
 
 	return con
 }
@@ -81,6 +94,29 @@ func (c *Controller) SetBrokers(b []string) {
 	brokers = b
 }
 
+// SetDeadLetterRouter enables the dead letter endpoints
+func (c *Controller) SetDeadLetterRouter(router *deadletter.Router) {
+	c.DeadLetters = NewDeadLetterController(c.User.DB, router)
+}
+
+// SetAdmin enables the cluster administration and consumer group endpoints,
+// and lets the readiness check reach Kafka
+func (c *Controller) SetAdmin(admin *kafka.Admin) {
+	c.Admin = NewAdminController(c.User.DB, admin)
+	c.Groups = NewGroupController(admin)
+	c.Health = NewHealthController(admin)
+}
+
+// SetDurableProduce makes async produce requests go through the outbox
+func (c *Controller) SetDurableProduce(enabled bool) {
+	c.User.Durable = enabled
+}
+
+// SetTransactionalProducer enables the transactional produce endpoint
+func (c *Controller) SetTransactionalProducer(p *kafka.TransactionalProducer) {
+	transactionalProducer = p
+}
+
 func (c *Controller) SetSaramaConfig(config *sarama.Config) {
 	c.SaramaConfig = config
 }
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/cploutarchou/go-kafka-rest/deadletter"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/cploutarchou/go-kafka-rest/types"
+	"github.com/cploutarchou/go-kafka-rest/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeadLetterLimit = 50
+	maxDeadLetterLimit     = 500
+)
+
+type DeadLetterController struct {
+	DB     *gorm.DB
+	Router *deadletter.Router
+}
+
+func NewDeadLetterController(db *gorm.DB, router *deadletter.Router) DeadLetterController {
+	return DeadLetterController{DB: db, Router: router}
+}
+
+// ListDeadLetters lists dead letters newest first. The topic and pending
+// query parameters filter them, limit and offset page through them.
+func (dc *DeadLetterController) ListDeadLetters(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", defaultDeadLetterLimit)
+	if limit <= 0 {
+		return utils.RespondError(c, fiber.StatusBadRequest, "limit must be a positive number")
+	}
+	if limit > maxDeadLetterLimit {
+		limit = maxDeadLetterLimit
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		return utils.RespondError(c, fiber.StatusBadRequest, "offset must not be negative")
+	}
+	pending, _ := strconv.ParseBool(c.Query("pending"))
+	keyFormat, valueFormat, err := recordFormats(c)
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	deadLetters, total, err := models.ListDeadLetters(dc.DB, models.DeadLetterFilter{Topic: c.Query("topic"), Pending: pending}, limit, offset)
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusInternalServerError, "Failed to list dead letters")
+	}
+	data := make([]types.DeadLetter, len(deadLetters))
+	for i := range deadLetters {
+		data[i] = toDeadLetter(&deadLetters[i], keyFormat, valueFormat)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"dead_letters": data,
+		"total":        total,
+	}})
+}
+
+// RedriveDeadLetter produces a dead letter to its original topic again
+func (dc *DeadLetterController) RedriveDeadLetter(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, "invalid dead letter id")
+	}
+	keyFormat, valueFormat, err := recordFormats(c)
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	record, metadata, err := dc.Router.Redrive(id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return utils.RespondError(c, fiber.StatusNotFound, "dead letter not found")
+	case errors.Is(err, deadletter.ErrAlreadyRedriven):
+		return utils.RespondError(c, fiber.StatusConflict, err.Error())
+	case err != nil && record == nil:
+		return utils.RespondError(c, fiber.StatusInternalServerError, "Failed to read dead letter")
+	case err != nil:
+		return utils.RespondError(c, produceErrorStatus(err), err.Error())
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"dead_letter": toDeadLetter(record, keyFormat, valueFormat),
+		"result":      toProduceResult(record.MessageID, metadata),
+	}})
+}
+
+func toDeadLetter(record *models.DeadLetter, keyFormat string, valueFormat string) types.DeadLetter {
+	return types.DeadLetter{
+		ID:             record.ID.String(),
+		Topic:          record.Topic,
+		Partition:      record.Partition,
+		Key:            encodeEmbedded(keyFormat, record.Key),
+		Value:          encodeEmbedded(valueFormat, record.Value),
+		Headers:        record.Headers,
+		Error:          record.Error,
+		Attempts:       record.Attempts,
+		FirstAttemptAt: record.FirstAttemptAt,
+		LastAttemptAt:  record.LastAttemptAt,
+		PublishedTo:    record.PublishedTo,
+		RedrivenAt:     record.RedrivenAt,
+		CreatedAt:      record.CreatedAt,
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Embedded formats of keys and values, they say how the bytes of a record
+// are written in the JSON of requests and responses
+const (
+	// FormatString is UTF-8 text in a JSON string, the default
+	FormatString = "string"
+	// FormatBinary is base64 in a JSON string
+	FormatBinary = "binary"
+	// FormatJSON is any JSON value, stored as compact JSON
+	FormatJSON = "json"
+)
+
+func checkFormat(format string) error {
+	switch format {
+	case "", FormatString, FormatBinary, FormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatString, FormatBinary, FormatJSON)
+	}
+}
+
+// isMissing reports whether an embedded value was left out or null. An empty
+// string only counts as missing in the string format, in binary it is empty
+// data and in json a JSON string.
+func isMissing(format string, raw json.RawMessage) bool {
+	if len(raw) == 0 || string(raw) == "null" {
+		return true
+	}
+	return (format == "" || format == FormatString) && string(raw) == `""`
+}
+
+// decodeEmbedded turns an embedded key or value of a request into the bytes to produce
+func decodeEmbedded(format string, raw json.RawMessage) ([]byte, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJSON:
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, fmt.Errorf("invalid json: %w", err)
+		}
+		return compact.Bytes(), nil
+	case FormatBinary:
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, fmt.Errorf("binary data must be a base64 string")
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %w", err)
+		}
+		return data, nil
+	default:
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return nil, fmt.Errorf("string data must be a JSON string")
+		}
+		return []byte(text), nil
+	}
+}
+
+// encodeEmbedded writes the bytes of a consumed key or value in the format.
+// Data that isn't valid JSON is written as a string when json is asked for.
+func encodeEmbedded(format string, data []byte) json.RawMessage {
+	if data == nil {
+		return json.RawMessage("null")
+	}
+	var encoded []byte
+	switch format {
+	case FormatJSON:
+		if json.Valid(data) {
+			return json.RawMessage(data)
+		}
+		encoded, _ = json.Marshal(string(data))
+	case FormatBinary:
+		encoded, _ = json.Marshal(base64.StdEncoding.EncodeToString(data))
+	default:
+		encoded, _ = json.Marshal(string(data))
+	}
+	return encoded
+}
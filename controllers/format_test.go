@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cploutarchou/go-kafka-rest/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckFormat(t *testing.T) {
+	for _, format := range []string{"", FormatString, FormatBinary, FormatJSON} {
+		assert.NoError(t, checkFormat(format), format)
+	}
+	assert.Error(t, checkFormat("avro"))
+}
+
+func TestIsMissing(t *testing.T) {
+	for _, test := range []struct {
+		format  string
+		raw     string
+		missing bool
+	}{
+		{"", "", true},
+		{FormatBinary, "null", true},
+		{"", `""`, true},
+		{FormatString, `""`, true},
+		{FormatBinary, `""`, false},
+		{FormatJSON, `""`, false},
+		{FormatString, `"a"`, false},
+		{FormatJSON, "0", false},
+	} {
+		assert.Equal(t, test.missing, isMissing(test.format, json.RawMessage(test.raw)), "%s %s", test.format, test.raw)
+	}
+}
+
+func TestEmbeddedRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		raw    string
+		data   string
+		// encoded is what the data is written back as, raw when empty
+		encoded string
+	}{
+		{FormatString, `"héllo"`, "héllo", ""},
+		{"", `"plain"`, "plain", `"plain"`},
+		{FormatBinary, `"AAEC/w=="`, "\x00\x01\x02\xff", ""},
+		{FormatBinary, `""`, "", ""},
+		{FormatJSON, `{ "id": 1, "tags": ["a"] }`, `{"id":1,"tags":["a"]}`, `{"id":1,"tags":["a"]}`},
+		{FormatJSON, `""`, `""`, ""},
+		{FormatJSON, `null`, `null`, ""},
+	} {
+		data, err := decodeEmbedded(test.format, json.RawMessage(test.raw))
+		assert.NoError(t, err, test.raw)
+		assert.Equal(t, test.data, string(data), test.raw)
+
+		encoded := test.encoded
+		if encoded == "" {
+			encoded = test.raw
+		}
+		assert.Equal(t, encoded, string(encodeEmbedded(test.format, data)), test.raw)
+	}
+
+	assert.Equal(t, "null", string(encodeEmbedded(FormatString, nil)), "records without key")
+	assert.Equal(t, `"not json"`, string(encodeEmbedded(FormatJSON, []byte("not json"))))
+}
+
+func TestDecodeEmbeddedErrors(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		raw    string
+	}{
+		{FormatBinary, `"not base64!"`},
+		{FormatBinary, `42`},
+		{FormatJSON, `{"id":`},
+		{FormatString, `{"id":1}`},
+		{"avro", `"a"`},
+	} {
+		_, err := decodeEmbedded(test.format, json.RawMessage(test.raw))
+		assert.Error(t, err, "%s %s", test.format, test.raw)
+	}
+}
+
+func TestToMessage(t *testing.T) {
+	message, err := toMessage(types.MessagePayload{
+		Topic:       "orders",
+		Key:         json.RawMessage(`"b3JkZXItMQ=="`),
+		KeyFormat:   FormatBinary,
+		Data:        json.RawMessage(`{"id": 1}`),
+		ValueFormat: FormatJSON,
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, message.ID)
+	assert.Equal(t, "orders", message.Topic)
+	assert.Equal(t, []byte("order-1"), message.Key)
+	assert.Equal(t, []byte(`{"id":1}`), message.Value)
+
+	message, err = toMessage(types.MessagePayload{Topic: "orders", Key: json.RawMessage(`""`), Data: json.RawMessage(`"a"`)})
+	assert.NoError(t, err)
+	assert.Nil(t, message.Key, "an empty string key is no key")
+
+	_, err = toMessage(types.MessagePayload{Topic: "orders", Data: json.RawMessage(`"a"`), KeyFormat: "avro"})
+	assert.ErrorContains(t, err, "invalid key")
+	_, err = toMessage(types.MessagePayload{Topic: "orders", Data: json.RawMessage(`"%%%"`), ValueFormat: FormatBinary})
+	assert.ErrorContains(t, err, "invalid data")
+}
+
+func TestSendMessageRejectsInvalidData(t *testing.T) {
+	app := fiber.New()
+	app.Post("/messages", (&UserController{}).SendMessage)
+
+	for _, body := range []string{
+		`{"topic":"orders","key":"a","data":"%%%","value_format":"binary"}`,
+		`{"topic":"orders","key":"a","data":{"id":},"value_format":"json"}`,
+		`{"topic":"orders","key":"a","data":{"id":1}}`,
+		`{"topic":"orders","key":"a","data":""}`,
+	} {
+		request := httptest.NewRequest(fiber.MethodPost, "/messages", strings.NewReader(body))
+		request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		response, err := app.Test(request)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusBadRequest, response.StatusCode, body)
+	}
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/types"
+	"github.com/cploutarchou/go-kafka-rest/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultLagSummaryLimit = 20
+	maxLagSummaryLimit     = 1000
+)
+
+// GroupController reports on the consumer groups of the cluster and how far behind they are
+type GroupController struct {
+	Admin *kafka.Admin
+}
+
+func NewGroupController(admin *kafka.Admin) GroupController {
+	return GroupController{Admin: admin}
+}
+
+func (gc *GroupController) ListGroups(c *fiber.Ctx) error {
+	groups, err := gc.Admin.ListGroups()
+	if err != nil {
+		return respondGroupError(c, err)
+	}
+	data := make([]types.ConsumerGroup, len(groups))
+	for i, group := range groups {
+		data[i] = types.ConsumerGroup{Group: group.Group, ProtocolType: group.ProtocolType}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"groups": data}})
+}
+
+func (gc *GroupController) DescribeGroup(c *fiber.Ctx) error {
+	group, err := gc.Admin.DescribeGroup(c.Params("group"))
+	if err != nil {
+		return respondGroupError(c, err)
+	}
+	description := types.GroupDescription{
+		Group:        group.Group,
+		State:        group.State,
+		ProtocolType: group.ProtocolType,
+		Protocol:     group.Protocol,
+		Members:      make([]types.GroupMember, len(group.Members)),
+	}
+	for i, member := range group.Members {
+		assignment := make([]types.TopicPartition, len(member.Assignment))
+		for j, tp := range member.Assignment {
+			assignment[j] = types.TopicPartition{Topic: tp.Topic, Partition: tp.Partition}
+		}
+		description.Members[i] = types.GroupMember{
+			MemberID:   member.MemberID,
+			InstanceID: member.InstanceID,
+			ClientID:   member.ClientID,
+			ClientHost: member.ClientHost,
+			Assignment: assignment,
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": description})
+}
+
+// GroupLag reports the lag of a group on every partition it committed an offset for
+func (gc *GroupController) GroupLag(c *fiber.Ctx) error {
+	lag, err := gc.Admin.GroupLag(c.Params("group"))
+	if err != nil {
+		return respondGroupError(c, err)
+	}
+	data := toGroupLag(lag)
+	data.Partitions = make([]types.PartitionLag, len(lag.Partitions))
+	for i, partition := range lag.Partitions {
+		data.Partitions[i] = types.PartitionLag{
+			Topic:         partition.Topic,
+			Partition:     partition.Partition,
+			Committed:     partition.Committed,
+			HighWaterMark: partition.HighWaterMark,
+			Lag:           partition.Lag,
+			MemberID:      partition.MemberID,
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": data})
+}
+
+// LagSummary ranks the groups, and the topics each group reads, by lag. The
+// limit query parameter caps both lists.
+func (gc *GroupController) LagSummary(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", defaultLagSummaryLimit)
+	if limit <= 0 {
+		return utils.RespondError(c, fiber.StatusBadRequest, "limit must be a positive number")
+	}
+	if limit > maxLagSummaryLimit {
+		limit = maxLagSummaryLimit
+	}
+
+	summary, err := gc.Admin.LagSummary()
+	if err != nil {
+		return respondGroupError(c, err)
+	}
+	var total int64
+	groups := make([]types.GroupLag, 0, limit)
+	for i := range summary.Groups {
+		total += summary.Groups[i].Lag
+		if i < limit {
+			groups = append(groups, toGroupLag(&summary.Groups[i]))
+		}
+	}
+	topics := make([]types.TopicLag, 0, limit)
+	for i := 0; i < len(summary.Topics) && i < limit; i++ {
+		topic := summary.Topics[i]
+		topics = append(topics, types.TopicLag{Group: topic.Group, Topic: topic.Topic, Lag: topic.Lag})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"total_lag": total,
+		"groups":    groups,
+		"topics":    topics,
+	}})
+}
+
+func toGroupLag(lag *kafka.GroupLag) types.GroupLag {
+	return types.GroupLag{Group: lag.Group, State: lag.State, Lag: lag.Lag}
+}
+
+func respondGroupError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, kafka.ErrGroupNotFound) {
+		return utils.RespondError(c, fiber.StatusNotFound, err.Error())
+	}
+	return utils.RespondError(c, adminErrorStatus(err), err.Error())
+}
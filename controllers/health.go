@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cploutarchou/go-kafka-rest/initializers"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// healthCheckTimeout bounds each readiness check, probes give up soon
+const healthCheckTimeout = 3 * time.Second
+
+const (
+	healthUp   = "up"
+	healthDown = "down"
+)
+
+type HealthController struct {
+	Admin *kafka.Admin
+}
+
+func NewHealthController(admin *kafka.Admin) HealthController {
+	return HealthController{Admin: admin}
+}
+
+// Live reports that the process serves requests, it checks no dependency
+func (hc *HealthController) Live(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"status": healthUp}})
+}
+
+// Ready checks that Postgres and Kafka can be reached, the reply is 503 when either can't
+func (hc *HealthController) Ready(c *fiber.Ctx) error {
+	checks := map[string]func(ctx context.Context) error{
+		"database": checkDatabase,
+		"kafka":    hc.checkKafka,
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]types.HealthCheck, len(checks))
+	ready := true
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+			defer cancel()
+
+			result := types.HealthCheck{Status: healthUp}
+			if err := check(ctx); err != nil {
+				result = types.HealthCheck{Status: healthDown, Error: err.Error()}
+			}
+			mutex.Lock()
+			results[name] = result
+			ready = ready && result.Status == healthUp
+			mutex.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	if !ready {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "fail", "data": fiber.Map{"status": healthDown, "checks": results}})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"status": healthUp, "checks": results}})
+}
+
+func checkDatabase(ctx context.Context) error {
+	db := initializers.GetDB()
+	if db == nil {
+		return errors.New("not connected")
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// checkKafka sends a metadata request, sarama can't cancel it so it is
+// abandoned when the context is done
+func (hc *HealthController) checkKafka(ctx context.Context) error {
+	if hc.Admin == nil {
+		return errors.New("not connected")
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- hc.Admin.Ping()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/cploutarchou/go-kafka-rest/types"
+	"github.com/cploutarchou/go-kafka-rest/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SyncProduceHeader asks for a synchronous produce, like the sync query parameter
+const SyncProduceHeader = "X-Sync-Produce"
+
+// syncRequested reports whether the caller wants to wait for the broker's acknowledgement
+func syncRequested(c *fiber.Ctx) bool {
+	value := c.Query("sync")
+	if value == "" {
+		value = c.Get(SyncProduceHeader)
+	}
+	sync, _ := strconv.ParseBool(value)
+	return sync
+}
+
+// produceErrorStatus maps a produce error to the HTTP status reported to the caller.
+// Errors caused by the request are 4xx, an unavailable cluster is 503 and the rest 502.
+func produceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sarama.ErrUnknownTopicOrPartition):
+		return fiber.StatusNotFound
+	case errors.Is(err, sarama.ErrInvalidTopic),
+		errors.Is(err, sarama.ErrInvalidPartition),
+		errors.Is(err, sarama.ErrInvalidMessage),
+		errors.Is(err, sarama.ErrInvalidRecord),
+		errors.Is(err, sarama.ErrInvalidTimestamp):
+		return fiber.StatusBadRequest
+	case errors.Is(err, sarama.ErrMessageSizeTooLarge):
+		return fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, sarama.ErrTopicAuthorizationFailed),
+		errors.Is(err, sarama.ErrClusterAuthorizationFailed):
+		return fiber.StatusForbidden
+	case errors.Is(err, sarama.ErrOutOfBrokers),
+		errors.Is(err, sarama.ErrNotEnoughReplicas),
+		errors.Is(err, sarama.ErrNotEnoughReplicasAfterAppend),
+		errors.Is(err, sarama.ErrLeaderNotAvailable),
+		errors.Is(err, sarama.ErrNotLeaderForPartition),
+		errors.Is(err, sarama.ErrRequestTimedOut),
+		errors.Is(err, sarama.ErrShuttingDown),
+		errors.Is(err, sarama.ErrClosedClient):
+		return fiber.StatusServiceUnavailable
+	default:
+		return fiber.StatusBadGateway
+	}
+}
+
+// SendMessages produces a batch of records synchronously and reports on each
+// of them. Invalid or failed records don't stop the others, the reply is 207
+// when only part of the batch was written.
+func (u *UserController) SendMessages(c *fiber.Ctx) error {
+	var payload types.BatchPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	results := make([]types.BatchRecordResult, len(payload.Records))
+	messages := make([]kafka.Message, 0, len(payload.Records))
+	// indexes maps the messages sent to their position in the batch
+	indexes := make([]int, 0, len(payload.Records))
+	for i, record := range payload.Records {
+		results[i].Topic = record.Topic
+		switch {
+		case record.Topic == "":
+			results[i].Status, results[i].Error = fiber.StatusBadRequest, "Topic is missing"
+			continue
+		case isMissing(record.ValueFormat, record.Data):
+			results[i].Status, results[i].Error = fiber.StatusBadRequest, "Data is missing"
+			continue
+		}
+		message, err := toMessage(record)
+		if err != nil {
+			results[i].Status, results[i].Error = fiber.StatusBadRequest, err.Error()
+			continue
+		}
+		results[i].ID = message.ID
+		messages = append(messages, message)
+		indexes = append(indexes, i)
+	}
+
+	if len(messages) > 0 {
+		for j, result := range producer.SendBatch(messages) {
+			i := indexes[j]
+			if result.Err != nil {
+				results[i].Status, results[i].Error = produceErrorStatus(result.Err), result.Err.Error()
+				continue
+			}
+			metadata := result.Metadata
+			results[i].Status = fiber.StatusOK
+			results[i].Partition = &metadata.Partition
+			results[i].Offset = &metadata.Offset
+			results[i].Timestamp = &metadata.Timestamp
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Status != fiber.StatusOK {
+			failed++
+		}
+	}
+	status := fiber.StatusOK
+	if failed > 0 {
+		status = fiber.StatusMultiStatus
+	}
+	return c.Status(status).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	}})
+}
+
+// SendTransaction produces a batch of records, possibly to several topics, in
+// one Kafka transaction. Unlike SendMessages the batch succeeds or fails as a
+// whole: an invalid record rejects it and a failed write aborts it.
+func (u *UserController) SendTransaction(c *fiber.Ctx) error {
+	if transactionalProducer == nil {
+		return utils.RespondError(c, fiber.StatusNotImplemented, kafka.ErrTransactionsDisabled.Error())
+	}
+
+	var payload types.BatchPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	errors := models.ValidateStruct(payload)
+	if errors != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprint(errors))
+	}
+
+	messages := make([]kafka.Message, len(payload.Records))
+	for i, record := range payload.Records {
+		switch {
+		case record.Topic == "":
+			return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprintf("record %d: Topic is missing", i))
+		case isMissing(record.ValueFormat, record.Data):
+			return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprintf("record %d: Data is missing", i))
+		}
+		message, err := toMessage(record)
+		if err != nil {
+			return utils.RespondError(c, fiber.StatusBadRequest, fmt.Sprintf("record %d: %v", i, err))
+		}
+		messages[i] = message
+	}
+
+	metadata, err := transactionalProducer.SendTransaction(messages)
+	if err != nil {
+		return utils.RespondError(c, produceErrorStatus(err), fmt.Sprintf("transaction aborted: %v", err))
+	}
+	results := make([]types.ProduceResult, len(metadata))
+	for i := range metadata {
+		results[i] = toProduceResult(messages[i].ID, metadata[i])
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"results": results}})
+}
+
+// toMessage decodes the embedded key and value of the payload, a missing key
+// produces a record without key (see isMissing). The message gets a new id.
+func toMessage(payload types.MessagePayload) (kafka.Message, error) {
+	message := kafka.Message{ID: uuid.NewString(), Topic: payload.Topic, Headers: payload.Headers, Partition: payload.Partition}
+
+	value, err := decodeEmbedded(payload.ValueFormat, payload.Data)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("invalid data: %w", err)
+	}
+	message.Value = value
+
+	if !isMissing(payload.KeyFormat, payload.Key) {
+		key, err := decodeEmbedded(payload.KeyFormat, payload.Key)
+		if err != nil {
+			return kafka.Message{}, fmt.Errorf("invalid key: %w", err)
+		}
+		message.Key = key
+	} else if err := checkFormat(payload.KeyFormat); err != nil {
+		return kafka.Message{}, fmt.Errorf("invalid key: %w", err)
+	}
+	return message, nil
+}
+
+// recordFormats reads the key_format and value_format query parameters of consume requests
+func recordFormats(c *fiber.Ctx) (keyFormat string, valueFormat string, err error) {
+	keyFormat, valueFormat = c.Query("key_format", FormatString), c.Query("value_format", FormatString)
+	if err := checkFormat(keyFormat); err != nil {
+		return "", "", fmt.Errorf("invalid key_format: %w", err)
+	}
+	if err := checkFormat(valueFormat); err != nil {
+		return "", "", fmt.Errorf("invalid value_format: %w", err)
+	}
+	return keyFormat, valueFormat, nil
+}
+
+// toConsumerRecord is how records read back from Kafka are shown to clients
+func toConsumerRecord(record *kafka.Record, keyFormat string, valueFormat string) types.ConsumerRecord {
+	return types.ConsumerRecord{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Key:       encodeEmbedded(keyFormat, record.Key),
+		Value:     encodeEmbedded(valueFormat, record.Value),
+		Timestamp: record.Timestamp,
+		Headers:   record.Headers,
+	}
+}
+
+func toProduceResult(id string, metadata kafka.RecordMetadata) types.ProduceResult {
+	return types.ProduceResult{
+		ID:        id,
+		Topic:     metadata.Topic,
+		Partition: metadata.Partition,
+		Offset:    metadata.Offset,
+		Timestamp: metadata.Timestamp,
+	}
+}
+
+// MessageStatus reports what became of a message accepted with the id. Recent
+// messages are answered from the producer's delivery reports, older ones from
+// the dead letters and the outbox.
+func (u *UserController) MessageStatus(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if report, ok := producer.DeliveryReport(id); ok {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": reportStatus(report)})
+	}
+
+	if _, err := uuid.Parse(id); err == nil {
+		deadLetter, err := models.FindDeadLetterByMessageID(u.DB, id)
+		if err == nil {
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": deadLetterStatus(deadLetter)})
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, fiber.StatusInternalServerError, err.Error())
+		}
+
+		message, err := models.FindOutboxMessage(u.DB, uuid.MustParse(id))
+		if err == nil {
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": outboxStatus(message)})
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.RespondError(c, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+	return utils.RespondError(c, fiber.StatusNotFound, "Unknown message id")
+}
+
+func reportStatus(report kafka.DeliveryReport) types.MessageStatus {
+	status := types.MessageStatus{
+		ID:       report.ID,
+		Topic:    report.Topic,
+		Status:   report.Status,
+		Attempts: report.Attempts,
+		Error:    report.Error,
+	}
+	if report.Status == kafka.DeliveryDelivered {
+		status.Partition, status.Offset, status.Timestamp = &report.Partition, &report.Offset, &report.Timestamp
+	}
+	return status
+}
+
+// deadLetterStatus is delivered once the dead letter was re-driven, failed before
+func deadLetterStatus(deadLetter *models.DeadLetter) types.MessageStatus {
+	status := types.MessageStatus{
+		ID:       deadLetter.MessageID,
+		Topic:    deadLetter.Topic,
+		Status:   kafka.DeliveryFailed,
+		Attempts: deadLetter.Attempts,
+		Error:    deadLetter.Error,
+	}
+	if deadLetter.RedrivenAt != nil {
+		status.Status, status.Error = kafka.DeliveryDelivered, ""
+	}
+	return status
+}
+
+func outboxStatus(message *models.OutboxMessage) types.MessageStatus {
+	status := types.MessageStatus{
+		ID:       message.ID.String(),
+		Topic:    message.Topic,
+		Status:   kafka.DeliveryPending,
+		Attempts: message.Attempts,
+		Error:    message.LastError,
+	}
+	if message.Status == models.OutboxSent {
+		status.Status, status.Error = kafka.DeliveryDelivered, ""
+		status.Partition, status.Offset, status.Timestamp = message.SentPartition, message.SentOffset, message.SentAt
+	}
+	return status
+}
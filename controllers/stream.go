@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// sseHeartbeat is how often a comment is written to idle streams, it keeps
+// proxies from timing out and notices clients that went away
+const sseHeartbeat = 15 * time.Second
+
+type StreamController struct {
+	Brokers []string
+	Factory kafka.ConsumerFactory
+}
+
+func NewStreamController(brokers []string, factory kafka.ConsumerFactory) StreamController {
+	return StreamController{Brokers: brokers, Factory: factory}
+}
+
+// StreamTopic streams the records of a topic as server-sent events. The id of
+// every event lists partition:offset of the last record sent on every
+// partition, or the offset before the start of partitions that sent none, so
+// a client reconnecting with Last-Event-ID picks up where it left. Without it
+// the stream starts live, or at the since query parameter.
+func (sc *StreamController) StreamTopic(c *fiber.Ctx) error {
+	topic := c.Params("topic")
+
+	positions, err := parseEventID(c.Get("Last-Event-ID"))
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	from := kafka.Position{Offsets: make(map[int32]int64, len(positions))}
+	for partition, offset := range positions {
+		from.Offsets[partition] = offset + 1
+	}
+	keyFormat, valueFormat, err := recordFormats(c)
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	if since := c.Query("since"); since != "" {
+		from.Timestamp, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return utils.RespondError(c, fiber.StatusBadRequest, "since must be an RFC 3339 timestamp")
+		}
+	}
+
+	stream, err := kafka.NewStreamFrom(sc.Brokers, nil, sc.Factory, topic, from)
+	if errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+		return utils.RespondError(c, fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return utils.RespondError(c, fiber.StatusBadGateway, err.Error())
+	}
+	// every event id lists every partition, so a client reconnecting before
+	// a partition sent it anything doesn't skip to the end of that partition
+	positions = stream.SeenOffsets()
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			if err := stream.Close(); err != nil {
+				log.Printf("failed to close stream of topic %s: %v", topic, err)
+			}
+		}()
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case record, ok := <-stream.Records():
+				if !ok {
+					return
+				}
+				positions[record.Partition] = record.Offset
+				data, err := json.Marshal(toConsumerRecord(record, keyFormat, valueFormat))
+				if err != nil {
+					log.Printf("failed to marshal record: %v", err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: record\ndata: %s\n\n", formatEventID(positions), data)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			// flushing fails once the client is gone
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// parseEventID reads an event id of comma separated partition:offset pairs,
+// offset -1 stands for a partition where nothing was seen from offset 0
+func parseEventID(id string) (map[int32]int64, error) {
+	positions := make(map[int32]int64)
+	if id == "" {
+		return positions, nil
+	}
+	for _, pair := range strings.Split(id, ",") {
+		partition, offset, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid Last-Event-ID %q", id)
+		}
+		p, err := strconv.ParseInt(partition, 10, 32)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid partition in Last-Event-ID %q", id)
+		}
+		o, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || o < -1 {
+			return nil, fmt.Errorf("invalid offset in Last-Event-ID %q", id)
+		}
+		positions[int32(p)] = o
+	}
+	return positions, nil
+}
+
+func formatEventID(positions map[int32]int64) string {
+	partitions := make([]int32, 0, len(positions))
+	for partition := range positions {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	pairs := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		pairs = append(pairs, fmt.Sprintf("%d:%d", partition, positions[partition]))
+	}
+	return strings.Join(pairs, ",")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/cploutarchou/go-kafka-rest/initializers"
 	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/cploutarchou/go-kafka-rest/outbox"
 	"github.com/cploutarchou/go-kafka-rest/types"
 	"github.com/cploutarchou/go-kafka-rest/utils"
 	"github.com/gofiber/fiber/v2"
@@ -20,6 +21,8 @@ type UserController struct {
 	Model          models.User
 	DB             *gorm.DB
 	AuthController AuthController
+	// Durable sends async messages through the outbox instead of straight to Kafka
+	Durable bool
 }
 
 func (u *UserController) GetMe(c *fiber.Ctx) error {
@@ -137,15 +140,45 @@ func (u *UserController) SendMessage(c *fiber.Ctx) error {
 		})
 	}
 
-	if messagePayload.Data == "" {
+	if isMissing(messagePayload.ValueFormat, messagePayload.Data) {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"status":  "fail",
 			"message": "Data is missing",
 		})
 	}
 
+	message, err := toMessage(messagePayload)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"status":  "fail",
+			"message": err.Error(),
+		})
+	}
+
+	if syncRequested(c) {
+		metadata, err := producer.Send(message)
+		if err != nil {
+			return utils.RespondError(c, produceErrorStatus(err), err.Error())
+		}
+		return c.Status(http.StatusOK).JSON(fiber.Map{"status": "success", "data": toProduceResult(message.ID, metadata)})
+	}
+
+	if u.Durable {
+		id, err := outbox.Enqueue(u.DB, message)
+		if err != nil {
+			log.Printf("failed to store message in the outbox: %v", err)
+			return utils.RespondError(c, fiber.StatusServiceUnavailable, "Failed to store message")
+		}
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"status":  "success",
+			"message": "Message stored and queued for delivery",
+			"data":    fiber.Map{"id": id},
+		})
+	}
+
+	producer.Accepted(message)
 	mutex.Lock()
-	messageQueue = append(messageQueue, messagePayload)
+	messageQueue = append(messageQueue, message)
 	mutex.Unlock()
 
 	workerPool <- struct{}{}
@@ -167,7 +200,7 @@ func (u *UserController) SendMessage(c *fiber.Ctx) error {
 			messageQueue = messageQueue[1:]
 			mutex.Unlock()
 
-			producer.SendMessageAsync(message.Topic, message.Key, message.Data)
+			producer.SendAsync(message)
 			log.Printf("Kafka message produced! Topic: %s\n", message.Topic)
 		}
 	}()
@@ -175,5 +208,6 @@ func (u *UserController) SendMessage(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).JSON(fiber.Map{
 		"status":  "success",
 		"message": "Message received and added to the processing queue",
+		"data":    fiber.Map{"id": message.ID},
 	})
 }
@@ -0,0 +1,135 @@
+package deadletter
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Headers added to the records written to the dead letter topic
+const (
+	HeaderID           = "dlq-id"
+	HeaderTopic        = "dlq-original-topic"
+	HeaderPartition    = "dlq-original-partition"
+	HeaderError        = "dlq-error"
+	HeaderAttempts     = "dlq-attempts"
+	HeaderFirstAttempt = "dlq-first-attempt"
+	HeaderLastAttempt  = "dlq-last-attempt"
+)
+
+var ErrAlreadyRedriven = errors.New("dead letter was already re-driven")
+
+// Producer is the part of kafka.Producer the router needs
+type Producer interface {
+	Send(message kafka.Message) (kafka.RecordMetadata, error)
+}
+
+// Router takes the messages the producer gave up on. It writes them to the
+// dead letter topic and stores them in the database, which keeps them when
+// Kafka itself is down and lets them be listed and re-driven.
+type Router struct {
+	db       *gorm.DB
+	producer Producer
+	// topic is the dead letter topic, empty to only store dead letters
+	topic  string
+	Logger *log.Logger
+}
+
+func NewRouter(db *gorm.DB, producer Producer, topic string) *Router {
+	return &Router{
+		db:       db,
+		producer: producer,
+		topic:    topic,
+		Logger:   log.New(os.Stdout, "dead letters: ", log.LstdFlags),
+	}
+}
+
+// Handle routes a dead letter, it is a kafka.DeadLetterHandler
+func (r *Router) Handle(deadLetter kafka.DeadLetter) {
+	record := &models.DeadLetter{
+		ID:                 uuid.New(),
+		MessageID:          deadLetter.Message.ID,
+		Topic:              deadLetter.Message.Topic,
+		Partition:          deadLetter.Partition,
+		RequestedPartition: deadLetter.Message.Partition,
+		Key:                deadLetter.Message.Key,
+		Value:              deadLetter.Message.Value,
+		Headers:            deadLetter.Message.Headers,
+		Error:              deadLetter.Err.Error(),
+		Attempts:           deadLetter.Attempts,
+		FirstAttemptAt:     deadLetter.FirstAttempt,
+		LastAttemptAt:      deadLetter.LastAttempt,
+	}
+
+	if r.topic != "" && deadLetter.Message.Topic != r.topic {
+		if _, err := r.producer.Send(deadLetterMessage(r.topic, record)); err != nil {
+			r.Logger.Printf("Failed to write dead letter of topic %s to %s: %v", record.Topic, r.topic, err)
+		} else {
+			record.PublishedTo = r.topic
+		}
+	}
+
+	if err := models.CreateDeadLetter(r.db, record); err != nil {
+		r.Logger.Printf("Failed to store dead letter of topic %s: %v", record.Topic, err)
+	}
+}
+
+// Redrive produces the dead letter to its topic again. An error with a nil
+// dead letter means it couldn't be read or claimed, otherwise producing it failed.
+func (r *Router) Redrive(id uuid.UUID) (*models.DeadLetter, kafka.RecordMetadata, error) {
+	record, err := models.FindDeadLetter(r.db, id)
+	if err != nil {
+		return nil, kafka.RecordMetadata{}, err
+	}
+	if record.RedrivenAt != nil {
+		return record, kafka.RecordMetadata{}, ErrAlreadyRedriven
+	}
+	// claim the dead letter before producing it, a concurrent re-drive loses
+	claimed, err := record.ClaimRedrive(r.db, time.Now().UTC())
+	if err != nil {
+		return nil, kafka.RecordMetadata{}, err
+	}
+	if !claimed {
+		return record, kafka.RecordMetadata{}, ErrAlreadyRedriven
+	}
+
+	metadata, err := r.producer.Send(kafka.Message{
+		ID:        record.MessageID,
+		Topic:     record.Topic,
+		Key:       record.Key,
+		Value:     record.Value,
+		Headers:   record.Headers,
+		Partition: record.RequestedPartition,
+	})
+	if err != nil {
+		if releaseErr := record.ReleaseRedrive(r.db); releaseErr != nil {
+			r.Logger.Printf("Failed to release dead letter %s after a failed re-drive: %v", id, releaseErr)
+		}
+		return record, kafka.RecordMetadata{}, err
+	}
+	return record, metadata, nil
+}
+
+// deadLetterMessage is the record written to the dead letter topic: the
+// original key and value, with the failure told in headers
+func deadLetterMessage(topic string, record *models.DeadLetter) kafka.Message {
+	headers := make(map[string]string, len(record.Headers)+7)
+	for key, value := range record.Headers {
+		headers[key] = value
+	}
+	headers[HeaderID] = record.ID.String()
+	headers[HeaderTopic] = record.Topic
+	headers[HeaderPartition] = strconv.Itoa(int(record.Partition))
+	headers[HeaderError] = record.Error
+	headers[HeaderAttempts] = strconv.Itoa(record.Attempts)
+	headers[HeaderFirstAttempt] = record.FirstAttemptAt.Format(time.RFC3339Nano)
+	headers[HeaderLastAttempt] = record.LastAttemptAt.Format(time.RFC3339Nano)
+	return kafka.Message{Topic: topic, Key: record.Key, Value: record.Value, Headers: headers}
+}
@@ -0,0 +1,106 @@
+package deadletter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Shopify/sarama"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type sendProducer struct {
+	sent []kafka.Message
+	err  error
+}
+
+func (p *sendProducer) Send(message kafka.Message) (kafka.RecordMetadata, error) {
+	p.sent = append(p.sent, message)
+	return kafka.RecordMetadata{Topic: message.Topic, Offset: 7}, p.err
+}
+
+func TestDeadLetterMessage(t *testing.T) {
+	first := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := &models.DeadLetter{
+		ID:             uuid.New(),
+		Topic:          "orders",
+		Partition:      3,
+		Key:            []byte("42"),
+		Value:          []byte("created"),
+		Headers:        map[string]string{"tenant": "acme"},
+		Error:          "kafka server: Not enough in-sync replicas",
+		Attempts:       3,
+		FirstAttemptAt: first,
+		LastAttemptAt:  first.Add(time.Minute),
+	}
+
+	message := deadLetterMessage("dead-letters", record)
+	assert.Equal(t, "dead-letters", message.Topic)
+	assert.Equal(t, []byte("42"), message.Key, "the key should be kept so dead letters stay ordered")
+	assert.Equal(t, []byte("created"), message.Value)
+	assert.Equal(t, "acme", message.Headers["tenant"])
+	assert.Equal(t, record.ID.String(), message.Headers[HeaderID])
+	assert.Equal(t, "orders", message.Headers[HeaderTopic])
+	assert.Equal(t, "3", message.Headers[HeaderPartition])
+	assert.Equal(t, "3", message.Headers[HeaderAttempts])
+	assert.Equal(t, "2023-05-01T12:00:00Z", message.Headers[HeaderFirstAttempt])
+	assert.Len(t, record.Headers, 1, "the headers of the dead letter should not change")
+}
+
+func TestRedrive(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	producer := &sendProducer{}
+	router := NewRouter(gormDB, producer, "dead-letters")
+	id := uuid.New()
+	expectFind := func() {
+		mock.ExpectQuery(`SELECT \* FROM "dead_letters" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "message_id", "topic", "value", "error", "attempts"}).
+				AddRow(id, "message-1", "orders", []byte("created"), "timeout", 3))
+	}
+	claim := `UPDATE "dead_letters" SET "redriven_at"=\$1 WHERE id = \$2 AND redriven_at IS NULL`
+
+	// the re-drive that claims the dead letter produces it
+	expectFind()
+	mock.ExpectBegin()
+	mock.ExpectExec(claim).WithArgs(sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	record, metadata, err := router.Redrive(id)
+	assert.NoError(t, err)
+	assert.NotNil(t, record.RedrivenAt)
+	assert.Equal(t, int64(7), metadata.Offset)
+	assert.Len(t, producer.sent, 1)
+
+	// a re-drive that lost the race to another one produces nothing
+	expectFind()
+	mock.ExpectBegin()
+	mock.ExpectExec(claim).WithArgs(sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	_, _, err = router.Redrive(id)
+	assert.ErrorIs(t, err, ErrAlreadyRedriven)
+	assert.Len(t, producer.sent, 1)
+
+	// a failed re-drive gives the claim up
+	producer.err = sarama.ErrNotEnoughReplicas
+	expectFind()
+	mock.ExpectBegin()
+	mock.ExpectExec(claim).WithArgs(sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "dead_letters" SET "redriven_at"=\$1 WHERE id = \$2 AND redriven_at = \$3`).
+		WithArgs(nil, id, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	record, _, err = router.Redrive(id)
+	assert.ErrorIs(t, err, sarama.ErrNotEnoughReplicas)
+	assert.Nil(t, record.RedrivenAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,82 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/google/uuid"
+)
+
+// clusterEnvelope carries a broadcast between the instances of a cluster.
+// Origin is the instance that published it, which already delivered it to
+// its own clients and skips it when it comes back from Kafka.
+type clusterEnvelope struct {
+	Origin  string  `json:"origin"`
+	Message Message `json:"message"`
+}
+
+// defaultInstanceID is unique per process while staying recognisable in logs
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return uuid.NewString()
+	}
+	return fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8])
+}
+
+// publish hands a broadcast to the other instances through the cluster topic
+func (h *Hub) publish(producer MessageProducer, topic string, message Message) {
+	data, err := json.Marshal(clusterEnvelope{Origin: h.instanceID(), Message: message})
+	if err != nil {
+		h.Logger.Printf("Error marshalling cluster message: %v", err)
+		return
+	}
+	// keyed by room so the broadcasts of a room keep their order
+	producer.SendAsync(kafka.Message{Topic: topic, Key: []byte(message.Topic), Value: data})
+}
+
+// joinCluster fans the broadcasts of the other instances out to the local
+// clients until ctx is done
+func (h *Hub) joinCluster(ctx context.Context, topic string) error {
+	stream, err := kafka.NewStream(h.brokers, nil, h.consumerFactory, topic)
+	if err != nil {
+		return fmt.Errorf("failed to join cluster topic %s: %w", topic, err)
+	}
+	self := h.instanceID()
+
+	go func() {
+		<-ctx.Done()
+		if err := stream.Close(); err != nil {
+			h.Logger.Printf("Error closing cluster stream: %v", err)
+		}
+	}()
+	go func() {
+		for record := range stream.Records() {
+			var envelope clusterEnvelope
+			if err := json.Unmarshal(record.Value, &envelope); err != nil {
+				h.Logger.Printf("Error unmarshalling cluster message: %v", err)
+				continue
+			}
+			if envelope.Origin == self {
+				continue
+			}
+			select {
+			case h.Broadcast <- envelope.Message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	h.Logger.Printf("Instance %s joined cluster topic %s", self, topic)
+	return nil
+}
+
+func (h *Hub) instanceID() string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.options.InstanceID
+}
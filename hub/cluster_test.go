@@ -0,0 +1,84 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+// asyncProducer hands the values sent asynchronously to the test
+type asyncProducer struct {
+	mockProducer
+	sent chan string
+}
+
+func (p asyncProducer) SendAsync(message kafka.Message) {
+	p.sent <- string(message.Value)
+}
+
+func envelope(t *testing.T, origin string, message Message) *sarama.ConsumerMessage {
+	data, err := json.Marshal(clusterEnvelope{Origin: origin, Message: message})
+	assert.NoError(t, err)
+	return &sarama.ConsumerMessage{Value: data}
+}
+
+func TestHubCluster(t *testing.T) {
+	h := NewHub(nil, nil, nil).(*Hub)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.IsTest(true)
+	h.SetOptions(Options{ClusterTopic: "hub-cluster", InstanceID: "instance-a"})
+	producer := asyncProducer{sent: make(chan string, 1)}
+	h.producer = producer
+
+	// our own broadcast comes back from Kafka and must not be delivered twice
+	mockConsumer := mocks.NewConsumer(t, nil)
+	mockConsumer.SetTopicMetadata(map[string][]int32{"hub-cluster": {0}})
+	mockConsumer.ExpectConsumePartition("hub-cluster", 0, 0).
+		YieldMessage(envelope(t, "instance-a", Message{Type: TypeMessage, Topic: "test", Data: "local"})).
+		YieldMessage(envelope(t, "instance-b", Message{Type: TypeMessage, Topic: "test", Data: "remote"}))
+	h.consumerFactory = func(brokers []string, config *sarama.Config) (sarama.Client, sarama.Consumer, error) {
+		return mockClient{}, mockConsumer, nil
+	}
+
+	client := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 10),
+	}
+	h.RegisterClient(client)
+	assert.NoError(t, h.Subscribe(client, "test", nil))
+
+	go h.Run(ctx, log.New(os.Stdout, "HUB: ", log.Ldate|log.Ltime))
+
+	h.BroadcastMessage(Message{Type: TypeMessage, Topic: "test", Data: "local"})
+	select {
+	case value := <-producer.sent:
+		var published clusterEnvelope
+		assert.NoError(t, json.Unmarshal([]byte(value), &published))
+		assert.Equal(t, "instance-a", published.Origin)
+		assert.Equal(t, "local", published.Message.Data)
+	case <-time.After(time.Second):
+		t.Fatal("broadcast was not published to the cluster topic")
+	}
+
+	data := make([]string, 0, 2)
+	for len(data) < 2 {
+		data = append(data, receive(t, client).Data)
+	}
+	assert.ElementsMatch(t, []string{"local", "remote"}, data)
+	select {
+	case message := <-client.Send:
+		t.Fatalf("unexpected frame %v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, "instance-a", h.Stats().Instance)
+}
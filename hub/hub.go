@@ -3,11 +3,18 @@ package hub
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Shopify/sarama"
 	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/models"
 	"log"
+	"path"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
 )
@@ -15,16 +22,56 @@ import (
 var brokers []string         // Kafka brokers
 var producer *kafka.Producer // Kafka producer
 
+var (
+	ErrClientClosed   = errors.New("client is disconnected")
+	ErrMessageDropped = errors.New("client queue is full, a message was dropped")
+	ErrSlowClient     = errors.New("client queue is full")
+
+	ErrTooManyConnections     = errors.New("too many WebSocket connections")
+	ErrTooManyUserConnections = errors.New("too many WebSocket connections for this user")
+)
+
+// Message is the envelope of every frame exchanged over the socket, see protocol.go
 type Message struct {
-	Topic string `json:"topic"`
-	Key   string `json:"key"`
-	Data  string `json:"data"`
+	Version int    `json:"v,omitempty"`
+	Type    string `json:"type,omitempty"`
+	// ID is chosen by the client and echoed on the ack or error frame of the request
+	ID    string `json:"id,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	// Key and Data hold the bytes of the record, on the wire they are written
+	// in KeyFormat and ValueFormat, see MarshalJSON
+	Key  string `json:"key,omitempty"`
+	Data string `json:"data,omitempty"`
+	// KeyFormat and ValueFormat are asked for on subscribe and echoed on record frames
+	KeyFormat   string `json:"key_format,omitempty"`
+	ValueFormat string `json:"value_format,omitempty"`
+	// Headers are the Kafka record headers of produced and consumed records
+	Headers map[string]string `json:"headers,omitempty"`
+	// Keys optionally restricts a subscription to keys matching any of the glob patterns
+	Keys []string `json:"keys,omitempty"`
+	// Resume asks a subscription to replay the records missed since a reconnect
+	Resume *Resume `json:"resume,omitempty"`
+	// Partition is where a record was read or written, produce frames may set it to pick the partition
+	Partition *int32     `json:"partition,omitempty"`
+	Offset    *int64     `json:"offset,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Error     *Error     `json:"error,omitempty"`
+}
+
+// MessageProducer is the part of kafka.Producer the hub needs
+type MessageProducer interface {
+	Send(message kafka.Message) (kafka.RecordMetadata, error)
+	SendAsync(message kafka.Message)
 }
 
 type ConnectionInterface interface {
 	Close() error
 	WriteMessage(int, []byte) error
 	ReadMessage() (int, []byte, error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetReadLimit(limit int64)
+	SetPongHandler(h func(appData string) error)
 }
 
 type ClientInterface interface {
@@ -32,13 +79,40 @@ type ClientInterface interface {
 	WritePump(logger Logger)
 	GetConnection() ConnectionInterface
 	SendMessage(msg Message) error
+	QueueMessage(ctx context.Context, msg Message) error
 	CloseSend() error
+	IsClosed() bool
+	Subscribe(topic string, keys []string) bool
+	Unsubscribe(topic string) bool
+	Subscriptions() []string
+	Matches(msg Message) bool
+	SetFormats(topic string, keyFormat string, valueFormat string)
+	Formats(topic string) (keyFormat string, valueFormat string)
 }
 
 type Client struct {
-	Conn     ConnectionInterface
+	Conn ConnectionInterface
+	// Send is the client's queue, its capacity bounds how far the client may fall behind
 	Send     chan Message
+	Policy   OverflowPolicy
 	Producer kafka.Producer
+
+	// PingInterval, PongWait and WriteWait drive the heartbeat and the
+	// deadlines of the connection, zero disables them
+	PingInterval time.Duration
+	PongWait     time.Duration
+	WriteWait    time.Duration
+	// MaxMessageSize limits the size of incoming frames, zero means no limit
+	MaxMessageSize int64
+
+	// subscriptions maps each topic to the key patterns the client wants, none meaning every key
+	subscriptions map[string][]string
+	// formats maps topics to the key and value formats of their record frames
+	formats map[string][2]string
+	mutex   sync.Mutex
+	// closed guards Send, nothing may be sent once it is set
+	closed    bool
+	sendMutex sync.Mutex
 }
 
 type WebSocketConnection struct {
@@ -57,13 +131,88 @@ func (wsc *WebSocketConnection) ReadMessage() (int, []byte, error) {
 	return wsc.Conn.ReadMessage()
 }
 
+func (wsc *WebSocketConnection) SetReadDeadline(t time.Time) error {
+	return wsc.Conn.SetReadDeadline(t)
+}
+
+func (wsc *WebSocketConnection) SetWriteDeadline(t time.Time) error {
+	return wsc.Conn.SetWriteDeadline(t)
+}
+
+func (wsc *WebSocketConnection) SetReadLimit(limit int64) {
+	wsc.Conn.SetReadLimit(limit)
+}
+
+func (wsc *WebSocketConnection) SetPongHandler(h func(appData string) error) {
+	wsc.Conn.SetPongHandler(h)
+}
+
 func (c *Client) GetConnection() ConnectionInterface {
 	return c.Conn
 }
 
+// SendMessage queues the message without blocking. When the queue is full the
+// client's overflow policy applies, ErrMessageDropped reports a dropped
+// message and ErrSlowClient asks the caller to disconnect the client.
 func (c *Client) SendMessage(msg Message) error {
-	c.Send <- msg
-	return nil
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+	select {
+	case c.Send <- msg:
+		return nil
+	default:
+	}
+
+	switch c.Policy {
+	case OverflowDropNewest:
+		return ErrMessageDropped
+	case OverflowDisconnect:
+		// close right away so later frames are refused instead of counted again
+		c.closed = true
+		close(c.Send)
+		return ErrSlowClient
+	default:
+		// make room by discarding the oldest frame, the write pump may have
+		// emptied the queue meanwhile so neither operation may block
+		select {
+		case <-c.Send:
+		default:
+		}
+		select {
+		case c.Send <- msg:
+		default:
+		}
+		return ErrMessageDropped
+	}
+}
+
+// QueueMessage waits for room in the queue instead of applying the overflow
+// policy, it is used where dropping a frame would leave a gap
+func (c *Client) QueueMessage(ctx context.Context, msg Message) error {
+	for {
+		c.sendMutex.Lock()
+		if c.closed {
+			c.sendMutex.Unlock()
+			return ErrClientClosed
+		}
+		select {
+		case c.Send <- msg:
+			c.sendMutex.Unlock()
+			return nil
+		default:
+		}
+		c.sendMutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
 }
 
 func (c *Client) ReadMessage() (int, []byte, error) {
@@ -71,68 +220,238 @@ func (c *Client) ReadMessage() (int, []byte, error) {
 }
 
 func (c *Client) CloseSend() error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
 	close(c.Send)
 	return nil
 }
 
+func (c *Client) IsClosed() bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	return c.closed
+}
+
+// Subscribe sets the key patterns of the topic and reports whether the topic is new to the client
+func (c *Client) Subscribe(topic string, keys []string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string][]string)
+	}
+	_, ok := c.subscriptions[topic]
+	c.subscriptions[topic] = append([]string(nil), keys...)
+	return !ok
+}
+
+// Unsubscribe reports whether the client was subscribed to the topic
+func (c *Client) Unsubscribe(topic string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, ok := c.subscriptions[topic]
+	delete(c.subscriptions, topic)
+	delete(c.formats, topic)
+	return ok
+}
+
+// SetFormats sets how the keys and values of the records of the topic are written
+func (c *Client) SetFormats(topic string, keyFormat string, valueFormat string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.formats == nil {
+		c.formats = make(map[string][2]string)
+	}
+	c.formats[topic] = [2]string{keyFormat, valueFormat}
+}
+
+// Formats returns the key and value formats of the topic, empty for the string format
+func (c *Client) Formats(topic string) (string, string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	formats := c.formats[topic]
+	return formats[0], formats[1]
+}
+
+func (c *Client) Subscriptions() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	topics := make([]string, 0, len(c.subscriptions))
+	for topic := range c.subscriptions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Matches reports whether the message is on a subscribed topic and its key matches the patterns
+func (c *Client) Matches(msg Message) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	keys, ok := c.subscriptions[msg.Topic]
+	if !ok {
+		return false
+	}
+	if len(keys) == 0 {
+		return true
+	}
+	for _, pattern := range keys {
+		if matched, _ := path.Match(pattern, msg.Key); matched {
+			return true
+		}
+	}
+	return false
+}
+
 type TheHub interface {
+	Connect() error
 	Run(ctx context.Context, logger Logger)
 	RegisterClient(client ClientInterface)
 	UnregisterClient(client ClientInterface)
 	BroadcastMessage(message Message)
 	HandleWebSocketMessage(message Message)
+	HandleClientMessage(client ClientInterface, message Message)
+	Subscribe(client ClientInterface, topic string, keys []string) error
+	SubscribeFrom(client ClientInterface, topic string, keys []string, from Resume) error
+	Unsubscribe(client ClientInterface, topic string)
 	UpgradeWebSocket(c *websocket.Conn, logger Logger)
 	IsTest(isTest bool)
+	SetOptions(opts Options)
+	Stats() Stats
+}
+
+// Stats counts what happened to the frames the hub sent to its clients
+type Stats struct {
+	// Instance identifies this hub among the instances of a cluster
+	Instance string `json:"instance"`
+	Clients  int    `json:"clients"`
+	// Delivered frames were queued for a client
+	Delivered uint64 `json:"delivered"`
+	// Dropped frames were discarded because a client queue was full
+	Dropped uint64 `json:"dropped"`
+	// SlowDisconnects counts clients disconnected because their queue was full
+	SlowDisconnects uint64 `json:"slow_disconnects"`
+	// Rejected counts connections refused because of the connection limits
+	Rejected uint64 `json:"rejected"`
 }
 
 type Logger interface {
 	Printf(format string, v ...interface{})
 }
 
+// topicStream is a Kafka stream shared by every client subscribed to its topic
+type topicStream struct {
+	stream  *kafka.Stream
+	clients int
+}
+
 type Hub struct {
-	Clients      map[ClientInterface]bool
+	Clients map[ClientInterface]bool
+	// Rooms indexes the subscribed clients by topic
+	Rooms        map[string]map[ClientInterface]bool
 	Broadcast    chan Message
+	Records      chan Message
 	Register     chan ClientInterface
 	Unregister   chan ClientInterface
 	mutex        sync.RWMutex
 	Logger       Logger
-	producer     kafka.Producer
+	producer     MessageProducer
 	SaramaConfig *sarama.Config
 	isTest       bool
 	config       *sarama.Config
 	logger       *log.Logger
 	brokers      []string
+
+	consumerFactory kafka.ConsumerFactory
+	streams         map[string]*topicStream
+	// replays holds the resumed subscriptions by client and topic, guarded by mutex
+	replays      map[ClientInterface]map[string]*replay
+	streamsMutex sync.Mutex
+
+	options Options
+	// connections counts the open sockets per user, guarded by mutex
+	connections     map[string]int
+	open            int
+	rejected        atomic.Uint64
+	delivered       atomic.Uint64
+	dropped         atomic.Uint64
+	slowDisconnects atomic.Uint64
 }
 
 func NewHub(brokers_ []string, logger *log.Logger, config *sarama.Config) TheHub {
 
 	h := &Hub{
+		Logger:     log.New(log.Writer(), "hub: ", log.LstdFlags),
 		Clients:    make(map[ClientInterface]bool),
+		Rooms:      make(map[string]map[ClientInterface]bool),
 		Broadcast:  make(chan Message, 100),
+		Records:    make(chan Message, 100),
 		Register:   make(chan ClientInterface, 100),
 		Unregister: make(chan ClientInterface, 100),
 		brokers:    brokers_,
 		config:     config,
 		logger:     logger,
+
+		consumerFactory: kafka.TheConsumerFactory,
+		streams:         make(map[string]*topicStream),
+		replays:         make(map[ClientInterface]map[string]*replay),
+		options:         DefaultOptions(),
+		connections:     make(map[string]int),
+	}
+	if logger != nil {
+		h.Logger = logger
 	}
 
 	return h
 }
 
-func (h *Hub) Run(ctx context.Context, logger Logger) {
+// Connect starts the Kafka producer of the hub, which is guarded by h.mutex.
+// Calling it before Run lets the clients that connect while the hub starts
+// produce and broadcast.
+func (h *Hub) Connect() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.isTest || h.producer != nil {
+		return nil
+	}
 	var err error
+	if h.config == nil {
+		h.SaramaConfig = sarama.NewConfig()
+		producer, err = kafka.NewProducer(h.brokers, h.SaramaConfig, kafka.TheProducerFactory)
+	} else {
+		producer, err = kafka.NewProducer(brokers, h.SaramaConfig, kafka.TheProducerFactory)
+	}
+	if err != nil {
+		return err
+	}
+	h.producer = producer
+	return nil
+}
+
+// messageProducer returns the producer, nil until Connect ran
+func (h *Hub) messageProducer() MessageProducer {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.producer
+}
+
+func (h *Hub) Run(ctx context.Context, logger Logger) {
 	if !h.isTest {
-		if h.config == nil {
-			h.SaramaConfig = sarama.NewConfig()
-			producer, err = kafka.NewProducer(h.brokers, h.SaramaConfig, kafka.TheProducerFactory)
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			producer, err = kafka.NewProducer(brokers, h.SaramaConfig, kafka.TheProducerFactory)
-			if err != nil {
-				panic(err)
-			}
+		if err := h.Connect(); err != nil {
+			panic(err)
 		}
 
 		if logger == nil {
@@ -140,7 +459,15 @@ func (h *Hub) Run(ctx context.Context, logger Logger) {
 		} else {
 			h.Logger = logger
 		}
-		h.producer = *producer
+	}
+
+	h.mutex.RLock()
+	clusterTopic := h.options.ClusterTopic
+	h.mutex.RUnlock()
+	if clusterTopic != "" {
+		if err := h.joinCluster(ctx, clusterTopic); err != nil {
+			panic(err)
+		}
 	}
 	log.Print("🚀 Hub initialized")
 	for {
@@ -149,32 +476,282 @@ func (h *Hub) Run(ctx context.Context, logger Logger) {
 			logger.Printf("Hub shutting down...")
 			return
 		case client := <-h.Register:
-			h.mutex.Lock()
-			h.Clients[client] = true
-			h.mutex.Unlock()
-			logger.Printf("WebSocket connected")
+			h.register(client, logger)
 		case client := <-h.Unregister:
-			h.mutex.Lock()
-			if _, ok := h.Clients[client]; ok {
-				delete(h.Clients, client)
-				err := client.CloseSend()
-				if err != nil {
-					logger.Printf("Error closing send channel: %v", err)
-					return
-				}
-				logger.Printf("WebSocket disconnected")
-			}
-			h.mutex.Unlock()
+			h.unregister(client, logger)
 		case message := <-h.Broadcast:
-			h.mutex.RLock()
-			for client := range h.Clients {
-				err := client.SendMessage(message)
-				if err != nil {
-					logger.Printf("Failed to send message to client")
-				}
-			}
-			h.mutex.RUnlock()
+			h.deliver(message, logger)
+		case record := <-h.Records:
+			h.deliver(record, logger)
+		}
+	}
+}
+
+// register adds the client unless it is already closed, which happens when
+// its Unregister was handled first
+func (h *Hub) register(client ClientInterface, logger Logger) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if client.IsClosed() {
+		return
+	}
+	h.Clients[client] = true
+	logger.Printf("WebSocket connected")
+}
+
+// unregister closes the client and releases its rooms and streams, even if
+// its Register wasn't handled yet. A closed client can't be registered or
+// join rooms again.
+func (h *Hub) unregister(client ClientInterface, logger Logger) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	_, registered := h.Clients[client]
+	delete(h.Clients, client)
+	h.leaveRooms(client)
+	if err := client.CloseSend(); err != nil {
+		logger.Printf("Error closing send channel: %v", err)
+	}
+	if registered {
+		logger.Printf("WebSocket disconnected")
+	}
+}
+
+// deliver sends the message to the clients in the room of its topic whose key patterns match
+func (h *Hub) deliver(message Message, logger Logger) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for client := range h.Rooms[message.Topic] {
+		if !client.Matches(message) {
+			continue
+		}
+		if r := h.replays[client][message.Topic]; r != nil && !r.admit(message) {
+			continue
 		}
+		h.send(client, message, logger)
+	}
+}
+
+// send queues the message for the client and keeps the stats, it never blocks
+func (h *Hub) send(client ClientInterface, message Message, logger Logger) {
+	err := client.SendMessage(withFormats(client, message))
+	switch {
+	case err == nil:
+		h.delivered.Add(1)
+	case errors.Is(err, ErrMessageDropped):
+		h.dropped.Add(1)
+	case errors.Is(err, ErrSlowClient):
+		h.dropped.Add(1)
+		h.slowDisconnects.Add(1)
+		logger.Printf("Disconnecting slow client: %v", err)
+		// the hub loop may be the caller, so don't wait for it
+		go h.UnregisterClient(client)
+	default:
+		logger.Printf("Failed to send message to client: %v", err)
+	}
+}
+
+// SetOptions changes the options used for clients connecting from now on
+func (h *Hub) SetOptions(opts Options) {
+	opts = opts.withDefaults()
+	h.mutex.Lock()
+	h.options = opts
+	h.mutex.Unlock()
+}
+
+func (h *Hub) Stats() Stats {
+	h.mutex.RLock()
+	clients := len(h.Clients)
+	instance := h.options.InstanceID
+	h.mutex.RUnlock()
+
+	return Stats{
+		Instance:        instance,
+		Clients:         clients,
+		Delivered:       h.delivered.Load(),
+		Dropped:         h.dropped.Load(),
+		SlowDisconnects: h.slowDisconnects.Load(),
+		Rejected:        h.rejected.Load(),
+	}
+}
+
+// admit reserves a connection slot for the user or reports which limit is reached
+func (h *Hub) admit(user string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.options.MaxConnections > 0 && h.open >= h.options.MaxConnections {
+		h.rejected.Add(1)
+		return ErrTooManyConnections
+	}
+	if h.options.MaxConnectionsPerUser > 0 && h.connections[user] >= h.options.MaxConnectionsPerUser {
+		h.rejected.Add(1)
+		return ErrTooManyUserConnections
+	}
+	h.open++
+	h.connections[user]++
+	return nil
+}
+
+// leave frees the slot taken by admit
+func (h *Hub) leave(user string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.open--
+	h.connections[user]--
+	if h.connections[user] <= 0 {
+		delete(h.connections, user)
+	}
+}
+
+// Subscribe adds the client to the room of the topic, starting the Kafka
+// stream of the topic if the client is its first subscriber
+func (h *Hub) Subscribe(client ClientInterface, topic string, keys []string) error {
+	return h.subscribe(client, topic, keys, nil)
+}
+
+// subscribe joins the room, registering the replay in the same step so no
+// live record reaches the client before the replay is in place
+func (h *Hub) subscribe(client ClientInterface, topic string, keys []string, r *replay) error {
+	if topic == "" {
+		return fmt.Errorf("topic is missing")
+	}
+	for _, pattern := range keys {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid key pattern %q: %w", pattern, err)
+		}
+	}
+
+	h.mutex.RLock()
+	joined := h.Rooms[topic][client]
+	h.mutex.RUnlock()
+
+	// connecting to Kafka can take a while, don't hold up deliveries meanwhile
+	if !joined {
+		if err := h.acquireStream(topic); err != nil {
+			return err
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// a client that is already unregistered must not end up in a room again
+	if client.IsClosed() {
+		if !joined {
+			h.releaseStream(topic)
+		}
+		return ErrClientClosed
+	}
+	client.Subscribe(topic, keys)
+	if r != nil {
+		h.stopReplay(client, topic)
+		if h.replays[client] == nil {
+			h.replays[client] = make(map[string]*replay)
+		}
+		h.replays[client][topic] = r
+	}
+	if !joined {
+		if h.Rooms[topic] == nil {
+			h.Rooms[topic] = make(map[ClientInterface]bool)
+		}
+		h.Rooms[topic][client] = true
+	}
+	return nil
+}
+
+// Unsubscribe removes the client from the room of the topic
+func (h *Hub) Unsubscribe(client ClientInterface, topic string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !client.Unsubscribe(topic) {
+		return
+	}
+	h.leaveRoom(client, topic)
+}
+
+// leaveRooms must be called with h.mutex held
+func (h *Hub) leaveRooms(client ClientInterface) {
+	for _, topic := range client.Subscriptions() {
+		h.leaveRoom(client, topic)
+	}
+}
+
+// leaveRoom must be called with h.mutex held
+func (h *Hub) leaveRoom(client ClientInterface, topic string) {
+	if !h.Rooms[topic][client] {
+		return
+	}
+	delete(h.Rooms[topic], client)
+	if len(h.Rooms[topic]) == 0 {
+		delete(h.Rooms, topic)
+	}
+	h.stopReplay(client, topic)
+	h.releaseStream(topic)
+}
+
+// stopReplay must be called with h.mutex held
+func (h *Hub) stopReplay(client ClientInterface, topic string) {
+	r, ok := h.replays[client][topic]
+	if !ok {
+		return
+	}
+	r.cancel()
+	delete(h.replays[client], topic)
+	if len(h.replays[client]) == 0 {
+		delete(h.replays, client)
+	}
+}
+
+// acquireStream makes sure a Kafka stream is running for the topic
+func (h *Hub) acquireStream(topic string) error {
+	if h.isTest {
+		return nil
+	}
+
+	h.streamsMutex.Lock()
+	defer h.streamsMutex.Unlock()
+
+	if ts, ok := h.streams[topic]; ok {
+		ts.clients++
+		return nil
+	}
+
+	stream, err := kafka.NewStream(h.brokers, nil, h.consumerFactory, topic)
+	if err != nil {
+		return err
+	}
+	h.streams[topic] = &topicStream{stream: stream, clients: 1}
+
+	go func() {
+		for record := range stream.Records() {
+			h.Records <- recordMessage(record)
+		}
+		h.Logger.Printf("Stream for topic %s finished", topic)
+	}()
+	return nil
+}
+
+// releaseStream stops the Kafka stream of the topic once its last client is gone
+func (h *Hub) releaseStream(topic string) {
+	h.streamsMutex.Lock()
+	defer h.streamsMutex.Unlock()
+
+	ts, ok := h.streams[topic]
+	if !ok {
+		return
+	}
+	ts.clients--
+	if ts.clients > 0 {
+		return
+	}
+	delete(h.streams, topic)
+	if err := ts.stream.Close(); err != nil {
+		h.Logger.Printf("Error closing stream for topic %s: %v", topic, err)
 	}
 }
 
@@ -186,7 +763,15 @@ func (h *Hub) UnregisterClient(client ClientInterface) {
 	h.Unregister <- client
 }
 
+// BroadcastMessage delivers the message to the local clients of its room and,
+// in cluster mode, to the clients of the other instances
 func (h *Hub) BroadcastMessage(message Message) {
+	h.mutex.RLock()
+	clusterTopic, producer := h.options.ClusterTopic, h.producer
+	h.mutex.RUnlock()
+	if clusterTopic != "" && producer != nil {
+		h.publish(producer, clusterTopic, message)
+	}
 	h.Broadcast <- message
 }
 
@@ -196,9 +781,13 @@ func (h *Hub) HandleWebSocketMessage(message Message) {
 		return
 	}
 	// Send the message to Kafka
+	producer := h.messageProducer()
 	go func() {
-		value := message.Data
-		_, _, err := h.producer.SendMessageSync(message.Topic, message.Key, value)
+		if producer == nil {
+			h.Logger.Printf("Failed to send message to Kafka: producer is not available")
+			return
+		}
+		_, err := producer.Send(toKafkaMessage(message))
 		if err != nil {
 			h.Logger.Printf("Failed to send message to Kafka: %v", err)
 		}
@@ -208,12 +797,48 @@ func (h *Hub) HandleWebSocketMessage(message Message) {
 	h.BroadcastMessage(message)
 }
 
+// UpgradeWebSocket serves a WebSocket connection. The comma separated topics
+// query parameter subscribes the client to Kafka topics right away, more can
+// be added and removed later with control messages. Connections over the
+// limits are closed straight away with a try again later close frame.
 func (h *Hub) UpgradeWebSocket(c *websocket.Conn, logger Logger) {
+	h.mutex.RLock()
+	opts := h.options
+	h.mutex.RUnlock()
+
+	var user string
+	if u, ok := c.Locals("user").(models.UserResponse); ok {
+		user = u.ID.String()
+	}
+	if err := h.admit(user); err != nil {
+		logger.Printf("Refusing WebSocket connection: %v", err)
+		closeMessage := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error())
+		if err := c.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(opts.WriteWait)); err != nil {
+			logger.Printf("Error closing connection: %v", err)
+		}
+		return
+	}
+	defer h.leave(user)
+
 	client := &Client{
-		Conn: &WebSocketConnection{Conn: c},
-		Send: make(chan Message),
+		Conn:           &WebSocketConnection{Conn: c},
+		Send:           make(chan Message, opts.QueueSize),
+		Policy:         opts.OverflowPolicy,
+		PingInterval:   opts.PingInterval,
+		PongWait:       opts.PongWait,
+		WriteWait:      opts.WriteWait,
+		MaxMessageSize: opts.MaxMessageSize,
 	}
 	h.RegisterClient(client)
+	for _, topic := range strings.Split(c.Query("topics"), ",") {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			continue
+		}
+		if err := h.Subscribe(client, topic, nil); err != nil {
+			logger.Printf("Failed to subscribe to topic %s: %v", topic, err)
+		}
+	}
 
 	go func() {
 		defer h.UnregisterClient(client)
@@ -231,6 +856,14 @@ func (c *Client) WritePump(logger Logger) {
 		}
 	}()
 
+	// a nil channel never fires, which keeps the heartbeat off when no interval is set
+	var ping <-chan time.Time
+	if c.PingInterval > 0 {
+		ticker := time.NewTicker(c.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
 	for {
 		select {
 		case message, ok := <-c.Send:
@@ -242,13 +875,41 @@ func (c *Client) WritePump(logger Logger) {
 				return
 			}
 
-			err := c.GetConnection().WriteMessage(websocket.TextMessage, []byte(message.Data))
+			message.Version = ProtocolVersion
+			data, err := json.Marshal(message)
 			if err != nil {
+				logger.Printf("Error marshalling message: %v", err)
+				continue
+			}
+			if err := c.write(websocket.TextMessage, data); err != nil {
 				logger.Printf("Error writing message: %v", err)
 				return
 			}
+		case <-ping:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				logger.Printf("Error sending ping: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// write sends a frame within the write deadline
+func (c *Client) write(messageType int, data []byte) error {
+	if c.WriteWait > 0 {
+		if err := c.GetConnection().SetWriteDeadline(time.Now().Add(c.WriteWait)); err != nil {
+			return err
 		}
 	}
+	return c.GetConnection().WriteMessage(messageType, data)
+}
+
+// extendReadDeadline gives the client another PongWait to show it is alive
+func (c *Client) extendReadDeadline() error {
+	if c.PongWait <= 0 {
+		return nil
+	}
+	return c.GetConnection().SetReadDeadline(time.Now().Add(c.PongWait))
 }
 
 func (c *Client) ReadPump(hub TheHub, logger Logger) {
@@ -260,24 +921,40 @@ func (c *Client) ReadPump(hub TheHub, logger Logger) {
 		}
 	}()
 
+	if c.MaxMessageSize > 0 {
+		c.GetConnection().SetReadLimit(c.MaxMessageSize)
+	}
+	if err := c.extendReadDeadline(); err != nil {
+		logger.Printf("Error setting read deadline: %v", err)
+		return
+	}
+	c.GetConnection().SetPongHandler(func(string) error {
+		return c.extendReadDeadline()
+	})
+
 	for {
 		_, data, err := c.GetConnection().ReadMessage()
 		if err != nil {
 			logger.Printf("Error reading message: %v", err)
 			return
 		}
-
-		type ReceivedMessage struct {
-			Message Message `json:"message"`
+		if err := c.extendReadDeadline(); err != nil {
+			logger.Printf("Error setting read deadline: %v", err)
+			return
 		}
+
 		var message Message
 		err = json.Unmarshal(data, &message)
 		if err != nil {
 			logger.Printf("Error unmarshalling message: %v", err)
-			return
+			err = c.SendMessage(errorFrame("", ErrCodeInvalidMessage, err.Error()))
+			if errors.Is(err, ErrClientClosed) || errors.Is(err, ErrSlowClient) {
+				return
+			}
+			continue
 		}
 
-		hub.HandleWebSocketMessage(message) // Call the HandleWebSocketMessage method of the hub
+		hub.HandleClientMessage(c, message)
 	}
 }
 
@@ -3,10 +3,13 @@ package hub
 import (
 	"bytes"
 	"context"
+	"github.com/gofiber/websocket/v2"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"log"
 	"os"
 	"testing"
+	"time"
 )
 
 type MockConn struct {
@@ -21,8 +24,25 @@ func (MockConn) Close() error {
 	return nil
 }
 
-func (MockConn) ReadMessage() (int, []byte, error) {
-	return 0, []byte("mocked message"), nil
+func (MockConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (MockConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (MockConn) SetReadLimit(int64) {}
+
+func (MockConn) SetPongHandler(func(string) error) {}
+
+// ReadMessage returns whatever was written to the buffer as a single frame,
+// an empty buffer behaves like a closed connection
+func (m MockConn) ReadMessage() (int, []byte, error) {
+	if m.Buffer.Len() == 0 {
+		return 0, nil, io.EOF
+	}
+	return websocket.TextMessage, m.Buffer.Next(m.Buffer.Len()), nil
 }
 
 func TestHub(t *testing.T) {
@@ -32,9 +52,10 @@ func TestHub(t *testing.T) {
 	h.IsTest(true)
 	client := &Client{
 		Conn: &MockConn{bytes.NewBuffer(nil)},
-		Send: make(chan Message),
+		Send: make(chan Message, 1),
 	}
 	h.RegisterClient(client)
+	assert.NoError(t, h.Subscribe(client, "test", nil))
 
 	go h.Run(ctx, log.New(os.Stdout, "HUB: ", log.Ldate|log.Ltime))
 
@@ -45,12 +66,12 @@ func TestHub(t *testing.T) {
 	}
 	h.BroadcastMessage(message)
 
-	go func() {
-
-		receivedMessage := <-client.Send
-
+	select {
+	case receivedMessage := <-client.Send:
 		assert.Equal(t, message, receivedMessage, "received message does not match the broadcasted message")
-	}()
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive the broadcasted message")
+	}
 
 	h.UnregisterClient(client)
 }
@@ -93,3 +114,225 @@ func TestClient(t *testing.T) {
 	err := client.CloseSend()
 	assert.NoError(t, err, "closing client's send channel should not return error")
 }
+
+func TestHubRooms(t *testing.T) {
+	h := NewHub(nil, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.IsTest(true)
+
+	subscribed := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 1),
+	}
+	filtered := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 1),
+	}
+	other := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 1),
+	}
+	h.RegisterClient(subscribed)
+	h.RegisterClient(filtered)
+	h.RegisterClient(other)
+	assert.NoError(t, h.Subscribe(subscribed, "test", nil))
+	assert.NoError(t, h.Subscribe(filtered, "test", []string{"user-*"}))
+	assert.NoError(t, h.Subscribe(other, "other", nil))
+	assert.Error(t, h.Subscribe(other, "test", []string{"["}), "invalid key pattern should be rejected")
+
+	go h.Run(ctx, log.New(os.Stdout, "HUB: ", log.Ldate|log.Ltime))
+
+	record := Message{Topic: "test", Key: "key", Data: "value"}
+	h.(*Hub).Records <- record
+
+	select {
+	case received := <-subscribed.Send:
+		assert.Equal(t, record, received)
+	case <-time.After(time.Second):
+		t.Fatal("subscribed client did not receive the record")
+	}
+
+	matching := Message{Topic: "test", Key: "user-1", Data: "value"}
+	h.BroadcastMessage(matching)
+	select {
+	case received := <-filtered.Send:
+		assert.Equal(t, matching, received, "client filtering on keys should only receive matching keys")
+	case <-time.After(time.Second):
+		t.Fatal("client filtering on keys did not receive the matching message")
+	}
+	<-subscribed.Send
+	assert.Empty(t, other.Send, "client subscribed to another topic should not receive the record")
+
+	h.Unsubscribe(subscribed, "test")
+	assert.Equal(t, []string{}, subscribed.Subscriptions())
+	assert.False(t, h.(*Hub).Rooms["test"][subscribed])
+}
+
+func TestClientOverflowPolicies(t *testing.T) {
+	first := Message{Topic: "test", Data: "first"}
+	second := Message{Topic: "test", Data: "second"}
+
+	dropOldest := &Client{Send: make(chan Message, 1), Policy: OverflowDropOldest}
+	assert.NoError(t, dropOldest.SendMessage(first))
+	assert.ErrorIs(t, dropOldest.SendMessage(second), ErrMessageDropped)
+	assert.Equal(t, second, <-dropOldest.Send, "the oldest message should have been dropped")
+
+	dropNewest := &Client{Send: make(chan Message, 1), Policy: OverflowDropNewest}
+	assert.NoError(t, dropNewest.SendMessage(first))
+	assert.ErrorIs(t, dropNewest.SendMessage(second), ErrMessageDropped)
+	assert.Equal(t, first, <-dropNewest.Send, "the newest message should have been dropped")
+
+	disconnect := &Client{Send: make(chan Message, 1), Policy: OverflowDisconnect}
+	assert.NoError(t, disconnect.SendMessage(first))
+	assert.ErrorIs(t, disconnect.SendMessage(second), ErrSlowClient)
+
+	assert.NoError(t, disconnect.CloseSend())
+	assert.ErrorIs(t, disconnect.SendMessage(second), ErrClientClosed)
+}
+
+func TestHubSlowClient(t *testing.T) {
+	h := NewHub(nil, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.IsTest(true)
+
+	// nobody reads from this client, it must not hold up the hub
+	slow := &Client{
+		Conn:   &MockConn{bytes.NewBuffer(nil)},
+		Send:   make(chan Message, 1),
+		Policy: OverflowDisconnect,
+	}
+	fast := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 10),
+	}
+	h.RegisterClient(slow)
+	h.RegisterClient(fast)
+	assert.NoError(t, h.Subscribe(slow, "test", nil))
+	assert.NoError(t, h.Subscribe(fast, "test", nil))
+
+	go h.Run(ctx, log.New(os.Stdout, "HUB: ", log.Ldate|log.Ltime))
+
+	for i := 0; i < 3; i++ {
+		h.BroadcastMessage(Message{Topic: "test", Data: "value"})
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-fast.Send:
+		case <-time.After(time.Second):
+			t.Fatal("fast client was held up by the slow one")
+		}
+	}
+
+	assert.Eventually(t, slow.IsClosed, time.Second, 10*time.Millisecond, "slow client should be disconnected")
+	stats := h.Stats()
+	assert.Equal(t, uint64(1), stats.SlowDisconnects)
+	assert.GreaterOrEqual(t, stats.Dropped, uint64(1))
+}
+
+// closeFailingClient fails to close its queue
+type closeFailingClient struct {
+	*Client
+}
+
+func (c closeFailingClient) CloseSend() error {
+	c.Client.CloseSend()
+	return io.ErrClosedPipe
+}
+
+func TestHubUnregisterBeforeRegister(t *testing.T) {
+	h := NewHub(nil, nil, nil).(*Hub)
+	h.IsTest(true)
+	logger := log.New(io.Discard, "", 0)
+
+	client := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 1),
+	}
+	assert.NoError(t, h.Subscribe(client, "test", nil))
+	h.unregister(client, logger)
+	h.register(client, logger)
+
+	assert.Equal(t, 0, h.Stats().Clients, "a late register should be dropped")
+	assert.Empty(t, h.Rooms)
+	assert.True(t, client.IsClosed())
+	assert.ErrorIs(t, h.Subscribe(client, "test", nil), ErrClientClosed)
+}
+
+func TestHubSurvivesCloseErrors(t *testing.T) {
+	h := NewHub(nil, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.IsTest(true)
+
+	failing := closeFailingClient{&Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 1),
+	}}
+	other := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 1),
+	}
+	h.RegisterClient(failing)
+	h.RegisterClient(other)
+	assert.NoError(t, h.Subscribe(other, "test", nil))
+
+	go h.Run(ctx, log.New(io.Discard, "", 0))
+	h.UnregisterClient(failing)
+	assert.Eventually(t, func() bool { return h.Stats().Clients == 1 }, time.Second, 10*time.Millisecond)
+
+	message := Message{Topic: "test", Data: "value"}
+	h.BroadcastMessage(message)
+	select {
+	case received := <-other.Send:
+		assert.Equal(t, message, received)
+	case <-time.After(time.Second):
+		t.Fatal("the hub stopped after failing to close a client")
+	}
+}
+
+// pingConn records the type of every frame written to it
+type pingConn struct {
+	MockConn
+	types chan int
+}
+
+func (p *pingConn) WriteMessage(messageType int, data []byte) error {
+	p.types <- messageType
+	return nil
+}
+
+func TestWritePumpHeartbeat(t *testing.T) {
+	conn := &pingConn{MockConn: MockConn{bytes.NewBuffer(nil)}, types: make(chan int, 10)}
+	client := &Client{
+		Conn:         conn,
+		Send:         make(chan Message),
+		PingInterval: 10 * time.Millisecond,
+		WriteWait:    time.Second,
+	}
+	go client.WritePump(log.New(os.Stdout, "WRITEPUMP: ", log.Ldate|log.Ltime))
+	defer client.CloseSend()
+
+	select {
+	case messageType := <-conn.types:
+		assert.Equal(t, websocket.PingMessage, messageType, "an idle client should be pinged")
+	case <-time.After(time.Second):
+		t.Fatal("no ping was sent")
+	}
+}
+
+func TestHubConnectionLimits(t *testing.T) {
+	h := NewHub(nil, nil, nil).(*Hub)
+	h.SetOptions(Options{MaxConnections: 3, MaxConnectionsPerUser: 2})
+
+	assert.NoError(t, h.admit("alice"))
+	assert.NoError(t, h.admit("alice"))
+	assert.ErrorIs(t, h.admit("alice"), ErrTooManyUserConnections)
+	assert.NoError(t, h.admit("bob"))
+	assert.ErrorIs(t, h.admit("carol"), ErrTooManyConnections)
+
+	h.leave("alice")
+	assert.NoError(t, h.admit("carol"), "a freed slot should be reusable")
+	assert.Equal(t, uint64(2), h.Stats().Rejected)
+}
@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"fmt"
+	"time"
+)
+
+// OverflowPolicy decides what happens when a client's send queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest queued frame to make room for the new one
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDropNewest discards the frame that did not fit
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+	// OverflowDisconnect drops the slow client altogether
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+const (
+	DefaultQueueSize      = 256
+	DefaultPongWait       = 60 * time.Second
+	DefaultWriteWait      = 10 * time.Second
+	DefaultMaxMessageSize = 1 << 20
+	// DefaultReplayBufferSize is the number of live records held back per resumed subscription
+	DefaultReplayBufferSize = 4096
+)
+
+// Options holds the tunables of the hub
+type Options struct {
+	// QueueSize is the number of frames buffered per client
+	QueueSize      int
+	OverflowPolicy OverflowPolicy
+
+	// PingInterval is how often the client is pinged, it must be shorter than PongWait
+	PingInterval time.Duration
+	// PongWait is how long a client may stay silent before it is considered gone
+	PongWait time.Duration
+	// WriteWait bounds the time spent writing a single frame
+	WriteWait time.Duration
+	// MaxMessageSize is the largest frame accepted from a client, in bytes
+	MaxMessageSize int64
+
+	// MaxConnections caps the sockets served by the hub, zero means no limit
+	MaxConnections int
+	// MaxConnectionsPerUser caps the sockets of a single user, zero means no limit
+	MaxConnectionsPerUser int
+
+	// ReplayBufferSize bounds the live records held back while a resumed
+	// subscription catches up, a client going over it is disconnected
+	ReplayBufferSize int
+
+	// ClusterTopic turns on cluster mode, broadcasts are shared with the other
+	// instances through this internal topic
+	ClusterTopic string
+	// InstanceID identifies the hub in the cluster, it must differ between instances
+	InstanceID string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		QueueSize:        DefaultQueueSize,
+		OverflowPolicy:   OverflowDropOldest,
+		PingInterval:     DefaultPongWait * 9 / 10,
+		PongWait:         DefaultPongWait,
+		WriteWait:        DefaultWriteWait,
+		MaxMessageSize:   DefaultMaxMessageSize,
+		ReplayBufferSize: DefaultReplayBufferSize,
+		InstanceID:       defaultInstanceID(),
+	}
+}
+
+// withDefaults fills in the unset options
+func (o Options) withDefaults() Options {
+	defaults := DefaultOptions()
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaults.QueueSize
+	}
+	if o.OverflowPolicy == "" {
+		o.OverflowPolicy = defaults.OverflowPolicy
+	}
+	if o.PongWait <= 0 {
+		o.PongWait = defaults.PongWait
+	}
+	// a ping has to go out early enough for the pong to arrive in time
+	if o.PingInterval <= 0 || o.PingInterval >= o.PongWait {
+		o.PingInterval = o.PongWait * 9 / 10
+	}
+	if o.WriteWait <= 0 {
+		o.WriteWait = defaults.WriteWait
+	}
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if o.ReplayBufferSize <= 0 {
+		o.ReplayBufferSize = defaults.ReplayBufferSize
+	}
+	if o.InstanceID == "" {
+		o.InstanceID = defaults.InstanceID
+	}
+	return o
+}
+
+// ParseOverflowPolicy validates a policy read from configuration, empty means the default
+func ParseOverflowPolicy(policy string) (OverflowPolicy, error) {
+	switch OverflowPolicy(policy) {
+	case "":
+		return OverflowDropOldest, nil
+	case OverflowDropOldest, OverflowDropNewest, OverflowDisconnect:
+		return OverflowPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q", policy)
+	}
+}
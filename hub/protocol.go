@@ -0,0 +1,199 @@
+package hub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+)
+
+// ProtocolVersion is the version of the envelope written on every outgoing frame.
+// Incoming frames without a version are treated as the current one.
+const ProtocolVersion = 1
+
+// Envelope types. Clients send produce, subscribe, unsubscribe and ping,
+// the hub answers with ack, error and pong and pushes record and message frames.
+const (
+	TypeProduce     = "produce"
+	TypeSubscribe   = "subscribe"
+	TypeUnsubscribe = "unsubscribe"
+	TypePing        = "ping"
+	TypePong        = "pong"
+	TypeAck         = "ack"
+	TypeError       = "error"
+	// TypeRecord frames carry records consumed from Kafka
+	TypeRecord = "record"
+	// TypeMessage frames carry messages passed to BroadcastMessage
+	TypeMessage = "message"
+)
+
+// Formats of the keys and values of record frames, the same as for the REST
+// consumer
+const (
+	// FormatString is UTF-8 text in a JSON string, the default
+	FormatString = "string"
+	// FormatBinary is base64 in a JSON string
+	FormatBinary = "binary"
+	// FormatJSON is the JSON value itself, data that isn't JSON is written as a string
+	FormatJSON = "json"
+)
+
+// Error codes sent in error frames
+const (
+	ErrCodeInvalidMessage     = "invalid_message"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeInvalidRequest     = "invalid_request"
+	ErrCodeProduceFailed      = "produce_failed"
+	ErrCodeSubscribeFailed    = "subscribe_failed"
+	// ErrCodeResumeFailed means records may be missing from a resumed subscription
+	ErrCodeResumeFailed = "resume_failed"
+)
+
+// Error describes why a request sent over the socket failed
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// HandleClientMessage dispatches a frame received from the client on its type.
+// Failures are reported back to the client as error frames carrying the
+// correlation id of the request, the connection stays open.
+func (h *Hub) HandleClientMessage(client ClientInterface, message Message) {
+	if message.Version > ProtocolVersion {
+		h.reply(client, errorFrame(message.ID, ErrCodeUnsupportedVersion, fmt.Sprintf("protocol version %d is not supported", message.Version)))
+		return
+	}
+
+	switch message.Type {
+	case TypeProduce, "":
+		h.produce(client, message)
+	case TypeSubscribe:
+		if err := checkFormats(message.KeyFormat, message.ValueFormat); err != nil {
+			h.reply(client, errorFrame(message.ID, ErrCodeInvalidRequest, err.Error()))
+			return
+		}
+		// set before joining the room so the first record is already written in them
+		client.SetFormats(message.Topic, message.KeyFormat, message.ValueFormat)
+		var err error
+		if message.Resume != nil {
+			err = h.SubscribeFrom(client, message.Topic, message.Keys, *message.Resume)
+		} else {
+			err = h.Subscribe(client, message.Topic, message.Keys)
+		}
+		if err != nil {
+			h.reply(client, errorFrame(message.ID, ErrCodeSubscribeFailed, err.Error()))
+			return
+		}
+		h.reply(client, Message{Type: TypeAck, ID: message.ID, Topic: message.Topic})
+	case TypeUnsubscribe:
+		h.Unsubscribe(client, message.Topic)
+		h.reply(client, Message{Type: TypeAck, ID: message.ID, Topic: message.Topic})
+	case TypePing:
+		h.reply(client, Message{Type: TypePong, ID: message.ID})
+	default:
+		h.reply(client, errorFrame(message.ID, ErrCodeUnknownType, fmt.Sprintf("unknown message type %q", message.Type)))
+	}
+}
+
+// produce writes the message to Kafka and acks it with the partition and
+// offset it landed on. Subscribers of the topic, the sender included, get it
+// from the stream of the topic as a record frame.
+func (h *Hub) produce(client ClientInterface, message Message) {
+	if message.Topic == "" {
+		h.reply(client, errorFrame(message.ID, ErrCodeInvalidRequest, "topic is missing"))
+		return
+	}
+	if message.Data == "" {
+		h.reply(client, errorFrame(message.ID, ErrCodeInvalidRequest, "data is missing"))
+		return
+	}
+
+	producer := h.messageProducer()
+	if producer == nil {
+		h.reply(client, errorFrame(message.ID, ErrCodeProduceFailed, "producer is not available"))
+		return
+	}
+
+	go func() {
+		metadata, err := producer.Send(toKafkaMessage(message))
+		if err != nil {
+			h.Logger.Printf("Failed to send message to Kafka: %v", err)
+			h.reply(client, errorFrame(message.ID, ErrCodeProduceFailed, err.Error()))
+			return
+		}
+		h.reply(client, Message{
+			Type:      TypeAck,
+			ID:        message.ID,
+			Topic:     message.Topic,
+			Partition: &metadata.Partition,
+			Offset:    &metadata.Offset,
+			Timestamp: &metadata.Timestamp,
+		})
+	}()
+}
+
+func toKafkaMessage(message Message) kafka.Message {
+	msg := kafka.Message{Topic: message.Topic, Value: []byte(message.Data), Headers: message.Headers, Partition: message.Partition}
+	if message.Key != "" {
+		msg.Key = []byte(message.Key)
+	}
+	return msg
+}
+
+func (h *Hub) reply(client ClientInterface, message Message) {
+	h.send(client, message, h.Logger)
+}
+
+func checkFormats(formats ...string) error {
+	for _, format := range formats {
+		switch format {
+		case "", FormatString, FormatBinary, FormatJSON:
+		default:
+			return fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatString, FormatBinary, FormatJSON)
+		}
+	}
+	return nil
+}
+
+// withFormats sets the formats the client asked for on record frames
+func withFormats(client ClientInterface, message Message) Message {
+	if message.Type == TypeRecord {
+		message.KeyFormat, message.ValueFormat = client.Formats(message.Topic)
+	}
+	return message
+}
+
+// MarshalJSON writes the key and data in their formats
+func (m Message) MarshalJSON() ([]byte, error) {
+	type frame Message
+	return json.Marshal(struct {
+		frame
+		Key  json.RawMessage `json:"key,omitempty"`
+		Data json.RawMessage `json:"data,omitempty"`
+	}{frame(m), encodeFormat(m.KeyFormat, m.Key), encodeFormat(m.ValueFormat, m.Data)})
+}
+
+func encodeFormat(format string, data string) json.RawMessage {
+	if data == "" {
+		return nil
+	}
+	var encoded []byte
+	switch format {
+	case FormatJSON:
+		if json.Valid([]byte(data)) {
+			return json.RawMessage(data)
+		}
+		encoded, _ = json.Marshal(data)
+	case FormatBinary:
+		encoded, _ = json.Marshal(base64.StdEncoding.EncodeToString([]byte(data)))
+	default:
+		encoded, _ = json.Marshal(data)
+	}
+	return encoded
+}
+
+func errorFrame(id string, code string, message string) Message {
+	return Message{Type: TypeError, ID: id, Error: &Error{Code: code, Message: message}}
+}
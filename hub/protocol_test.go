@@ -0,0 +1,130 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockProducer struct {
+	err error
+}
+
+func (p mockProducer) Send(message kafka.Message) (kafka.RecordMetadata, error) {
+	if p.err != nil {
+		return kafka.RecordMetadata{}, p.err
+	}
+	return kafka.RecordMetadata{Topic: message.Topic, Partition: 2, Offset: 42, Timestamp: time.Now()}, nil
+}
+
+func (p mockProducer) SendAsync(message kafka.Message) {}
+
+func receive(t *testing.T, client *Client) Message {
+	select {
+	case message := <-client.Send:
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("no frame sent to the client")
+	}
+	return Message{}
+}
+
+func TestHandleClientMessage(t *testing.T) {
+	h := NewHub(nil, nil, nil)
+	h.IsTest(true)
+	h.(*Hub).producer = mockProducer{}
+	client := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 1),
+	}
+
+	h.HandleClientMessage(client, Message{Type: TypeProduce, ID: "1", Topic: "test", Key: "key", Data: "value"})
+	ack := receive(t, client)
+	assert.Equal(t, TypeAck, ack.Type)
+	assert.Equal(t, "1", ack.ID)
+	assert.Equal(t, int32(2), *ack.Partition)
+	assert.Equal(t, int64(42), *ack.Offset)
+	assert.Empty(t, h.(*Hub).Broadcast, "subscribers get produced records from the topic stream")
+
+	h.HandleClientMessage(client, Message{Type: TypeProduce, ID: "2", Topic: "test"})
+	failure := receive(t, client)
+	assert.Equal(t, TypeError, failure.Type)
+	assert.Equal(t, "2", failure.ID)
+	assert.Equal(t, ErrCodeInvalidRequest, failure.Error.Code)
+
+	h.(*Hub).producer = mockProducer{err: errors.New("broker down")}
+	h.HandleClientMessage(client, Message{Type: TypeProduce, ID: "3", Topic: "test", Data: "value"})
+	failure = receive(t, client)
+	assert.Equal(t, ErrCodeProduceFailed, failure.Error.Code)
+
+	h.HandleClientMessage(client, Message{Type: TypeSubscribe, ID: "4", Topic: "test"})
+	assert.Equal(t, Message{Type: TypeAck, ID: "4", Topic: "test"}, receive(t, client))
+	assert.Equal(t, []string{"test"}, client.Subscriptions())
+
+	h.HandleClientMessage(client, Message{Type: TypePing, ID: "5"})
+	assert.Equal(t, Message{Type: TypePong, ID: "5"}, receive(t, client))
+
+	h.HandleClientMessage(client, Message{Type: "bogus", ID: "6"})
+	assert.Equal(t, ErrCodeUnknownType, receive(t, client).Error.Code)
+
+	h.HandleClientMessage(client, Message{Version: ProtocolVersion + 1, Type: TypePing, ID: "7"})
+	assert.Equal(t, ErrCodeUnsupportedVersion, receive(t, client).Error.Code)
+}
+
+func TestReadPumpInvalidFrame(t *testing.T) {
+	h := NewHub(nil, nil, nil)
+	h.IsTest(true)
+	client := &Client{
+		Conn: &MockConn{bytes.NewBufferString("not json")},
+		Send: make(chan Message, 1),
+	}
+
+	// the pump answers with an error frame instead of dropping the connection,
+	// it only stops once the mocked connection runs out of frames
+	client.ReadPump(h, log.New(os.Stdout, "READPUMP: ", log.Ldate|log.Ltime))
+
+	failure := receive(t, client)
+	assert.Equal(t, TypeError, failure.Type)
+	assert.Equal(t, ErrCodeInvalidMessage, failure.Error.Code)
+
+	data, err := json.Marshal(failure)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"type":"error"`)
+}
+
+func TestRecordFormats(t *testing.T) {
+	h := NewHub(nil, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.IsTest(true)
+	binary := &Client{Conn: &MockConn{bytes.NewBuffer(nil)}, Send: make(chan Message, 1)}
+	plain := &Client{Conn: &MockConn{bytes.NewBuffer(nil)}, Send: make(chan Message, 1)}
+
+	h.HandleClientMessage(binary, Message{Type: TypeSubscribe, ID: "1", Topic: "test", KeyFormat: FormatJSON, ValueFormat: "avro"})
+	assert.Equal(t, ErrCodeInvalidRequest, receive(t, binary).Error.Code)
+	h.HandleClientMessage(binary, Message{Type: TypeSubscribe, ID: "2", Topic: "test", KeyFormat: FormatJSON, ValueFormat: FormatBinary})
+	assert.Equal(t, TypeAck, receive(t, binary).Type)
+	h.HandleClientMessage(plain, Message{Type: TypeSubscribe, ID: "3", Topic: "test"})
+	assert.Equal(t, TypeAck, receive(t, plain).Type)
+
+	go h.Run(ctx, log.New(os.Stdout, "HUB: ", log.Ldate|log.Ltime))
+	h.(*Hub).Records <- recordMessage(&kafka.Record{Topic: "test", Key: []byte(`{"id":1}`), Value: []byte{0x00, 0x01, 0xff}})
+
+	data, err := json.Marshal(receive(t, binary))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"key":{"id":1}`)
+	assert.Contains(t, string(data), `"data":"AAH/"`)
+	assert.Contains(t, string(data), `"value_format":"binary"`)
+
+	data, err = json.Marshal(receive(t, plain))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"key":"{\"id\":1}"`, "the string format stays the default")
+}
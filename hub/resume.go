@@ -0,0 +1,196 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+)
+
+// Resume is where a subscription picks up again after a reconnect
+type Resume struct {
+	// Offsets maps partitions to the offset of the last record the client saw
+	Offsets map[int32]int64 `json:"offsets,omitempty"`
+	// Timestamp is where partitions missing from Offsets start, without it they start live
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+var ErrReplayOverflow = errors.New("too many live records arrived while replaying")
+
+// replay tracks a resumed subscription. While the missed records are read
+// back from Kafka, live records for the client are held back; afterwards
+// they are let through, skipping whatever the replay already delivered.
+type replay struct {
+	mutex    sync.Mutex
+	cancel   context.CancelFunc
+	live     bool
+	pending  []Message
+	limit    int
+	overflow bool
+	// last is the offset of the last record delivered per partition
+	last map[int32]int64
+}
+
+func newReplay(cancel context.CancelFunc, limit int) *replay {
+	return &replay{cancel: cancel, limit: limit, last: make(map[int32]int64)}
+}
+
+// admit reports whether a live record may be sent to the client right away
+func (r *replay) admit(message Message) bool {
+	if message.Partition == nil || message.Offset == nil {
+		return true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.live {
+		if len(r.pending) >= r.limit {
+			r.overflow = true
+		} else {
+			r.pending = append(r.pending, message)
+		}
+		return false
+	}
+	return r.advance(message)
+}
+
+// advance records the record as delivered unless it already was, r.mutex must be held
+func (r *replay) advance(message Message) bool {
+	if last, ok := r.last[*message.Partition]; ok && *message.Offset <= last {
+		return false
+	}
+	r.last[*message.Partition] = *message.Offset
+	return true
+}
+
+// SubscribeFrom subscribes the client to the topic like Subscribe, but first
+// replays the records the client missed since the resume position.
+func (h *Hub) SubscribeFrom(client ClientInterface, topic string, keys []string, from Resume) error {
+	h.mutex.RLock()
+	limit := h.options.ReplayBufferSize
+	h.mutex.RUnlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newReplay(cancel, limit)
+	if err := h.subscribe(client, topic, keys, r); err != nil {
+		cancel()
+		return err
+	}
+
+	position := kafka.Position{Offsets: make(map[int32]int64, len(from.Offsets))}
+	for partition, offset := range from.Offsets {
+		position.Offsets[partition] = offset + 1
+	}
+	if from.Timestamp != nil {
+		position.Timestamp = *from.Timestamp
+	}
+
+	go func() {
+		err := h.replay(ctx, client, topic, position, r)
+		if ctx.Err() != nil {
+			// the client left the room meanwhile
+			return
+		}
+		if err != nil {
+			h.Logger.Printf("Replay of topic %s failed: %v", topic, err)
+			h.replyError(client, topic, err)
+		}
+		h.goLive(client, topic, r)
+	}()
+	return nil
+}
+
+// replay sends the client the records between the resume position and the
+// end of each partition as it was when the client joined the room
+func (h *Hub) replay(ctx context.Context, client ClientInterface, topic string, from kafka.Position, r *replay) error {
+	stream, err := kafka.NewStreamFrom(h.brokers, nil, h.consumerFactory, topic, from)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := stream.Close(); err != nil {
+			h.Logger.Printf("Error closing replay of topic %s: %v", topic, err)
+		}
+	}()
+
+	ends, err := stream.HighWaterMarks()
+	if err != nil {
+		return err
+	}
+	for partition, start := range stream.StartOffsets() {
+		if start >= ends[partition] {
+			delete(ends, partition)
+		}
+	}
+
+	for len(ends) > 0 {
+		var record *kafka.Record
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case record = <-stream.Records():
+		}
+		if record == nil {
+			return fmt.Errorf("replay of topic %s stopped early", topic)
+		}
+
+		message := recordMessage(record)
+		r.mutex.Lock()
+		fresh := r.advance(message)
+		r.mutex.Unlock()
+		if fresh && client.Matches(message) {
+			if err := client.QueueMessage(ctx, withFormats(client, message)); err != nil {
+				return err
+			}
+			h.delivered.Add(1)
+		}
+
+		if end, ok := ends[record.Partition]; ok && record.Offset >= end-1 {
+			delete(ends, record.Partition)
+		}
+	}
+	return nil
+}
+
+// goLive flushes the live records held back during the replay
+func (h *Hub) goLive(client ClientInterface, topic string, r *replay) {
+	r.mutex.Lock()
+	for _, message := range r.pending {
+		if r.advance(message) {
+			h.send(client, message, h.Logger)
+		}
+	}
+	r.pending = nil
+	r.live = true
+	overflow := r.overflow
+	r.mutex.Unlock()
+
+	if overflow {
+		// records were lost, the client has to resume again
+		h.replyError(client, topic, ErrReplayOverflow)
+		h.UnregisterClient(client)
+	}
+}
+
+func (h *Hub) replyError(client ClientInterface, topic string, err error) {
+	frame := errorFrame("", ErrCodeResumeFailed, err.Error())
+	frame.Topic = topic
+	h.reply(client, frame)
+}
+
+func recordMessage(record *kafka.Record) Message {
+	partition, offset, timestamp := record.Partition, record.Offset, record.Timestamp
+	return Message{
+		Type:      TypeRecord,
+		Topic:     record.Topic,
+		Key:       string(record.Key),
+		Data:      string(record.Value),
+		Headers:   record.Headers,
+		Partition: &partition,
+		Offset:    &offset,
+		Timestamp: &timestamp,
+	}
+}
@@ -0,0 +1,73 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockClient reports newest as the high water mark of every partition
+type mockClient struct {
+	sarama.Client
+	newest int64
+}
+
+func (c mockClient) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	return c.newest, nil
+}
+
+func (mockClient) Close() error {
+	return nil
+}
+
+func TestHubSubscribeFrom(t *testing.T) {
+	h := NewHub(nil, nil, nil).(*Hub)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.IsTest(true)
+
+	// the client saw offset 0 before it went away, 1 and 2 were produced meanwhile
+	mockConsumer := mocks.NewConsumer(t, nil)
+	mockConsumer.SetTopicMetadata(map[string][]int32{"test": {0}})
+	mockConsumer.ExpectConsumePartition("test", 0, 1).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("missed-1")}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("missed-2")})
+	h.consumerFactory = func(brokers []string, config *sarama.Config) (sarama.Client, sarama.Consumer, error) {
+		return mockClient{newest: 3}, mockConsumer, nil
+	}
+
+	client := &Client{
+		Conn: &MockConn{bytes.NewBuffer(nil)},
+		Send: make(chan Message, 10),
+	}
+	h.RegisterClient(client)
+	go h.Run(ctx, log.New(os.Stdout, "HUB: ", log.Ldate|log.Ltime))
+
+	assert.NoError(t, h.SubscribeFrom(client, "test", nil, Resume{Offsets: map[int32]int64{0: 0}}))
+
+	// the live stream overlaps with the replay
+	for _, offset := range []int64{2, 3} {
+		partition, offset := int32(0), offset
+		h.Records <- Message{Type: TypeRecord, Topic: "test", Data: "live", Partition: &partition, Offset: &offset}
+	}
+
+	offsets := make([]int64, 0, 3)
+	for len(offsets) < 3 {
+		select {
+		case received := <-client.Send:
+			offsets = append(offsets, *received.Offset)
+		case <-time.After(time.Second):
+			t.Fatalf("client only received offsets %v", offsets)
+		}
+	}
+	assert.Equal(t, []int64{1, 2, 3}, offsets, "records should be delivered once and in order")
+
+	h.UnregisterClient(client)
+}
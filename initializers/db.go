@@ -40,7 +40,7 @@ func ConnectDB(config *Config) {
 		DB.Logger = logger.Default.LogMode(logger.Info)
 
 		log.Println("Running Migrations")
-		err = DB.AutoMigrate(&models.User{})
+		err = DB.AutoMigrate(&models.User{}, &models.IdempotencyKey{}, &models.OutboxMessage{}, &models.DeadLetter{}, &models.AuditEntry{})
 		if err != nil {
 			log.Fatal("Migration Failed:\n", err.Error())
 		}
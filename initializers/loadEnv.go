@@ -27,10 +27,42 @@ type Config struct {
 	ClientOrigin       string   `mapstructure:"CLIENT_ORIGIN"`
 	CorsAllowedOrigins []string `mapstructure:"ALLOWED_ORIGINS"`
 
-	KafkaBrokers         string `mapstructure:"KAFKA_BROKERS"`
-	KafkaNumOfPartitions int    `mapstructure:"KAFKA_NUM_OF_PARTITIONS"`
-
-	EnableWebsocket bool `mapstructure:"ENABLE_WEBSOCKET"`
+	IdempotencyWindow time.Duration `mapstructure:"IDEMPOTENCY_WINDOW"`
+
+	KafkaBrokers           string `mapstructure:"KAFKA_BROKERS"`
+	KafkaNumOfPartitions   int    `mapstructure:"KAFKA_NUM_OF_PARTITIONS"`
+	KafkaReplicationFactor int    `mapstructure:"KAFKA_REPLICATION_FACTOR"`
+	KafkaPartitioner       string `mapstructure:"KAFKA_PARTITIONER"`
+	KafkaTopicPartitioners string `mapstructure:"KAFKA_TOPIC_PARTITIONERS"`
+	KafkaIdempotent        bool   `mapstructure:"KAFKA_IDEMPOTENT"`
+	KafkaTransactionalID   string `mapstructure:"KAFKA_TRANSACTIONAL_ID"`
+	KafkaAdminVersion      string `mapstructure:"KAFKA_ADMIN_VERSION"`
+
+	KafkaRetryMaxAttempts int           `mapstructure:"KAFKA_RETRY_MAX_ATTEMPTS"`
+	KafkaRetryMinBackoff  time.Duration `mapstructure:"KAFKA_RETRY_MIN_BACKOFF"`
+	KafkaRetryMaxBackoff  time.Duration `mapstructure:"KAFKA_RETRY_MAX_BACKOFF"`
+	KafkaDeadLetterTopic  string        `mapstructure:"KAFKA_DEAD_LETTER_TOPIC"`
+
+	DeliveryReportTTL   time.Duration `mapstructure:"DELIVERY_REPORT_TTL"`
+	DeliveryReportLimit int           `mapstructure:"DELIVERY_REPORT_LIMIT"`
+
+	OutboxEnabled       bool          `mapstructure:"OUTBOX_ENABLED"`
+	OutboxRelayInterval time.Duration `mapstructure:"OUTBOX_RELAY_INTERVAL"`
+	OutboxBatchSize     int           `mapstructure:"OUTBOX_BATCH_SIZE"`
+	OutboxMaxBackoff    time.Duration `mapstructure:"OUTBOX_MAX_BACKOFF"`
+	OutboxRetention     time.Duration `mapstructure:"OUTBOX_RETENTION"`
+
+	EnableWebsocket                bool          `mapstructure:"ENABLE_WEBSOCKET"`
+	WebsocketQueueSize             int           `mapstructure:"WEBSOCKET_QUEUE_SIZE"`
+	WebsocketOverflowPolicy        string        `mapstructure:"WEBSOCKET_OVERFLOW_POLICY"`
+	WebsocketPingInterval          time.Duration `mapstructure:"WEBSOCKET_PING_INTERVAL"`
+	WebsocketPongTimeout           time.Duration `mapstructure:"WEBSOCKET_PONG_TIMEOUT"`
+	WebsocketWriteTimeout          time.Duration `mapstructure:"WEBSOCKET_WRITE_TIMEOUT"`
+	WebsocketMaxMessageSize        int64         `mapstructure:"WEBSOCKET_MAX_MESSAGE_SIZE"`
+	WebsocketMaxConnections        int           `mapstructure:"WEBSOCKET_MAX_CONNECTIONS"`
+	WebsocketMaxConnectionsPerUser int           `mapstructure:"WEBSOCKET_MAX_CONNECTIONS_PER_USER"`
+	WebsocketClusterTopic          string        `mapstructure:"WEBSOCKET_CLUSTER_TOPIC"`
+	WebsocketInstanceID            string        `mapstructure:"WEBSOCKET_INSTANCE_ID"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -0,0 +1,299 @@
+package kafka
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// DefaultReplicationFactor is the replication factor of topics created
+// without one when the admin isn't given another
+const DefaultReplicationFactor int16 = 1
+
+// DefaultAdminVersion is the Kafka version the admin speaks when it isn't
+// given a config, the first with incremental config changes
+var DefaultAdminVersion = sarama.V2_3_0_0
+
+type AdminFactory func(brokers []string, conf *sarama.Config) (sarama.Client, sarama.ClusterAdmin, error)
+
+// TopicSpec describes a topic to create, zero partitions or replication
+// factor take the defaults of the admin
+type TopicSpec struct {
+	Name              string
+	Partitions        int32
+	ReplicationFactor int16
+	Configs           map[string]string
+}
+
+// TopicSummary is a topic as listed by the cluster, Configs holds only the
+// entries set on the topic itself
+type TopicSummary struct {
+	Name              string
+	Partitions        int32
+	ReplicationFactor int16
+	Configs           map[string]string
+}
+
+// PartitionDescription tells where the replicas of a partition are, all
+// values are broker ids
+type PartitionDescription struct {
+	Partition       int32
+	Leader          int32
+	Replicas        []int32
+	ISR             []int32
+	OfflineReplicas []int32
+}
+
+type TopicDescription struct {
+	Name              string
+	Internal          bool
+	ReplicationFactor int16
+	Partitions        []PartitionDescription
+}
+
+// ConfigEntry is the value of a topic config and where it comes from, the
+// value of sensitive configs is never returned
+type ConfigEntry struct {
+	Name      string
+	Value     string
+	Source    string
+	Default   bool
+	ReadOnly  bool
+	Sensitive bool
+}
+
+// Admin manages the topics of the cluster and inspects its consumer groups
+type Admin struct {
+	client            sarama.Client
+	admin             sarama.ClusterAdmin
+	offsetManagers    OffsetManagerFactory
+	partitions        int32
+	replicationFactor int16
+	version           sarama.KafkaVersion
+	// resetMutex keeps offset resets one at a time
+	resetMutex sync.Mutex
+	// previews holds the offset reset previews by token, guarded by previewMutex
+	previews     map[string]*resetPreview
+	previewTTL   time.Duration
+	previewMutex sync.Mutex
+}
+
+// NewAdmin connects to the cluster. Topics created without a partition count
+// get partitions, or 1 when it is 0, and replicationFactor likewise.
+func NewAdmin(brokers []string, conf *sarama.Config, factory AdminFactory, partitions int32, replicationFactor int16) (*Admin, error) {
+	config := conf
+	if config == nil {
+		config = sarama.NewConfig()
+		config.ClientID = "kafka-go"
+		config.Version = DefaultAdminVersion
+	}
+	// offsets are only committed by resets, on demand
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	if partitions <= 0 {
+		partitions = 1
+	}
+	if replicationFactor <= 0 {
+		replicationFactor = DefaultReplicationFactor
+	}
+
+	client, admin, err := factory(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Admin{
+		client:            client,
+		admin:             admin,
+		offsetManagers:    sarama.NewOffsetManagerFromClient,
+		partitions:        partitions,
+		replicationFactor: replicationFactor,
+		version:           config.Version,
+		previews:          make(map[string]*resetPreview),
+		previewTTL:        resetPreviewTTL,
+	}, nil
+}
+
+// ListTopics returns the topics of the cluster sorted by name
+func (a *Admin) ListTopics() ([]TopicSummary, error) {
+	details, err := a.admin.ListTopics()
+	if err != nil {
+		return nil, err
+	}
+	topics := make([]TopicSummary, 0, len(details))
+	for name, detail := range details {
+		topics = append(topics, TopicSummary{
+			Name:              name,
+			Partitions:        detail.NumPartitions,
+			ReplicationFactor: detail.ReplicationFactor,
+			Configs:           configValues(detail.ConfigEntries),
+		})
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	return topics, nil
+}
+
+// DescribeTopic returns the partitions of the topic, ErrUnknownTopicOrPartition
+// when there is no such topic
+func (a *Admin) DescribeTopic(name string) (*TopicDescription, error) {
+	metadata, err := a.admin.DescribeTopics([]string{name})
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	topic := metadata[0]
+	if !errors.Is(topic.Err, sarama.ErrNoError) {
+		return nil, topic.Err
+	}
+
+	description := &TopicDescription{Name: topic.Name, Internal: topic.IsInternal}
+	for _, partition := range topic.Partitions {
+		description.Partitions = append(description.Partitions, PartitionDescription{
+			Partition:       partition.ID,
+			Leader:          partition.Leader,
+			Replicas:        partition.Replicas,
+			ISR:             partition.Isr,
+			OfflineReplicas: partition.OfflineReplicas,
+		})
+		if replicas := int16(len(partition.Replicas)); replicas > description.ReplicationFactor {
+			description.ReplicationFactor = replicas
+		}
+	}
+	sort.Slice(description.Partitions, func(i, j int) bool {
+		return description.Partitions[i].Partition < description.Partitions[j].Partition
+	})
+	return description, nil
+}
+
+// CreateTopic creates the topic and returns its spec with the defaults filled in
+func (a *Admin) CreateTopic(spec TopicSpec) (TopicSpec, error) {
+	if spec.Partitions <= 0 {
+		spec.Partitions = a.partitions
+	}
+	if spec.ReplicationFactor <= 0 {
+		spec.ReplicationFactor = a.replicationFactor
+	}
+	detail := &sarama.TopicDetail{
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		ConfigEntries:     make(map[string]*string, len(spec.Configs)),
+	}
+	for name, value := range spec.Configs {
+		value := value
+		detail.ConfigEntries[name] = &value
+	}
+	if err := a.admin.CreateTopic(spec.Name, detail, false); err != nil {
+		return TopicSpec{}, err
+	}
+	return spec, nil
+}
+
+// DeleteTopic deletes the topic and its records
+func (a *Admin) DeleteTopic(name string) error {
+	return a.admin.DeleteTopic(name)
+}
+
+// DescribeTopicConfig returns the configs of the topic sorted by name
+func (a *Admin) DescribeTopicConfig(topic string) ([]ConfigEntry, error) {
+	entries, err := a.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic})
+	if err != nil {
+		// sarama drops the error code when the broker explains the error,
+		// get ErrUnknownTopicOrPartition back for missing topics
+		if _, describeErr := a.DescribeTopic(topic); describeErr != nil {
+			return nil, describeErr
+		}
+		return nil, err
+	}
+	configs := make([]ConfigEntry, len(entries))
+	for i, entry := range entries {
+		configs[i] = ConfigEntry{
+			Name:      entry.Name,
+			Value:     entry.Value,
+			Source:    entry.Source.String(),
+			Default:   entry.Default,
+			ReadOnly:  entry.ReadOnly,
+			Sensitive: entry.Sensitive,
+		}
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return configs, nil
+}
+
+// AlterTopicConfig changes the configs of the topic named in changes and
+// leaves the other ones alone, a nil value reverts a config to its default.
+// With validateOnly the broker only checks the changes.
+func (a *Admin) AlterTopicConfig(topic string, changes map[string]*string, validateOnly bool) error {
+	if !a.version.IsAtLeast(sarama.V2_3_0_0) {
+		return a.replaceTopicConfig(topic, changes, validateOnly)
+	}
+	entries := make(map[string]sarama.IncrementalAlterConfigsEntry, len(changes))
+	for name, value := range changes {
+		operation := sarama.IncrementalAlterConfigsOperationSet
+		if value == nil {
+			operation = sarama.IncrementalAlterConfigsOperationDelete
+		}
+		entries[name] = sarama.IncrementalAlterConfigsEntry{Operation: operation, Value: value}
+	}
+	return a.admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, validateOnly)
+}
+
+// replaceTopicConfig changes configs on brokers older than Kafka 2.3, which
+// only replace the whole set of configs of a topic. The configs set on the
+// topic are read and sent back with the changes.
+func (a *Admin) replaceTopicConfig(topic string, changes map[string]*string, validateOnly bool) error {
+	entries, err := a.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic})
+	if err != nil {
+		if _, describeErr := a.DescribeTopic(topic); describeErr != nil {
+			return describeErr
+		}
+		return err
+	}
+	configs := make(map[string]*string)
+	for _, entry := range entries {
+		// the value of sensitive configs can't be read back, topics have none
+		if entry.Sensitive || entry.ReadOnly {
+			continue
+		}
+		// brokers before Kafka 1.1 don't say where a config comes from
+		override := entry.Source == sarama.SourceTopic || (entry.Source == sarama.SourceUnknown && !entry.Default)
+		if override {
+			value := entry.Value
+			configs[entry.Name] = &value
+		}
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(configs, name)
+			continue
+		}
+		configs[name] = value
+	}
+	return a.admin.AlterConfig(sarama.TopicResource, topic, configs, validateOnly)
+}
+
+// CreatePartitions raises the partition count of the topic to count, new
+// partitions are placed by the brokers. With validateOnly the broker only
+// checks the request.
+func (a *Admin) CreatePartitions(topic string, count int32, validateOnly bool) error {
+	return a.admin.CreatePartitions(topic, count, nil, validateOnly)
+}
+
+func (a *Admin) Close() error {
+	return a.admin.Close()
+}
+
+func configValues(entries map[string]*string) map[string]string {
+	if len(entries) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(entries))
+	for name, value := range entries {
+		if value != nil {
+			values[name] = *value
+		}
+	}
+	return values
+}
@@ -0,0 +1,245 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockClusterAdmin keeps topics in memory, like a cluster of one broker
+type mockClusterAdmin struct {
+	sarama.ClusterAdmin
+	topics map[string]sarama.TopicDetail
+}
+
+func newMockClusterAdmin() *mockClusterAdmin {
+	return &mockClusterAdmin{topics: make(map[string]sarama.TopicDetail)}
+}
+
+func (m *mockClusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return m.topics, nil
+}
+
+func (m *mockClusterAdmin) DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error) {
+	var metadata []*sarama.TopicMetadata
+	for _, name := range topics {
+		detail, ok := m.topics[name]
+		if !ok {
+			metadata = append(metadata, &sarama.TopicMetadata{Name: name, Err: sarama.ErrUnknownTopicOrPartition})
+			continue
+		}
+		topic := &sarama.TopicMetadata{Name: name}
+		for i := int32(0); i < detail.NumPartitions; i++ {
+			topic.Partitions = append(topic.Partitions, &sarama.PartitionMetadata{ID: i, Leader: 1, Replicas: []int32{1}, Isr: []int32{1}})
+		}
+		metadata = append(metadata, topic)
+	}
+	return metadata, nil
+}
+
+func (m *mockClusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	if _, ok := m.topics[topic]; ok {
+		return &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	}
+	if !validateOnly {
+		m.topics[topic] = *detail
+	}
+	return nil
+}
+
+func (m *mockClusterAdmin) DeleteTopic(topic string) error {
+	if _, ok := m.topics[topic]; !ok {
+		return sarama.ErrUnknownTopicOrPartition
+	}
+	delete(m.topics, topic)
+	return nil
+}
+
+func (m *mockClusterAdmin) Close() error {
+	return nil
+}
+
+func TestAdminTopics(t *testing.T) {
+	clusterAdmin := newMockClusterAdmin()
+	factory := func(brokers []string, conf *sarama.Config) (sarama.Client, sarama.ClusterAdmin, error) {
+		return mockClient{}, clusterAdmin, nil
+	}
+	admin, err := NewAdmin(nil, nil, factory, 6, 0)
+	assert.NoError(t, err)
+
+	spec, err := admin.CreateTopic(TopicSpec{Name: "orders", Configs: map[string]string{"cleanup.policy": "compact"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(6), spec.Partitions, "the default partition count should apply")
+	assert.Equal(t, DefaultReplicationFactor, spec.ReplicationFactor)
+	_, err = admin.CreateTopic(TopicSpec{Name: "audit", Partitions: 2, ReplicationFactor: 1})
+	assert.NoError(t, err)
+	_, err = admin.CreateTopic(TopicSpec{Name: "orders"})
+	assert.ErrorIs(t, err, sarama.ErrTopicAlreadyExists)
+
+	topics, err := admin.ListTopics()
+	assert.NoError(t, err)
+	assert.Len(t, topics, 2)
+	assert.Equal(t, "audit", topics[0].Name)
+	assert.Equal(t, int32(2), topics[0].Partitions)
+	assert.Equal(t, map[string]string{"cleanup.policy": "compact"}, topics[1].Configs)
+
+	description, err := admin.DescribeTopic("orders")
+	assert.NoError(t, err)
+	assert.Len(t, description.Partitions, 6)
+	assert.Equal(t, int16(1), description.ReplicationFactor)
+
+	assert.NoError(t, admin.DeleteTopic("orders"))
+	_, err = admin.DescribeTopic("orders")
+	assert.ErrorIs(t, err, sarama.ErrUnknownTopicOrPartition)
+	assert.NoError(t, admin.Close())
+}
+
+func (m *mockClusterAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	detail, ok := m.topics[resource.Name]
+	if !ok {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	var entries []sarama.ConfigEntry
+	for name, value := range map[string]string{"cleanup.policy": "delete", "retention.ms": "604800000"} {
+		if override, ok := detail.ConfigEntries[name]; ok {
+			entries = append(entries, sarama.ConfigEntry{Name: name, Value: *override, Source: sarama.SourceTopic})
+			continue
+		}
+		entries = append(entries, sarama.ConfigEntry{Name: name, Value: value, Default: true, Source: sarama.SourceDefault})
+	}
+	return entries, nil
+}
+
+func (m *mockClusterAdmin) IncrementalAlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]sarama.IncrementalAlterConfigsEntry, validateOnly bool) error {
+	detail, ok := m.topics[name]
+	if !ok {
+		return sarama.ErrUnknownTopicOrPartition
+	}
+	if validateOnly {
+		return nil
+	}
+	for config, entry := range entries {
+		if entry.Operation == sarama.IncrementalAlterConfigsOperationDelete {
+			delete(detail.ConfigEntries, config)
+			continue
+		}
+		detail.ConfigEntries[config] = entry.Value
+	}
+	return nil
+}
+
+func (m *mockClusterAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+	detail, ok := m.topics[topic]
+	if !ok {
+		return sarama.ErrUnknownTopicOrPartition
+	}
+	if count <= detail.NumPartitions {
+		return &sarama.TopicPartitionError{Err: sarama.ErrInvalidPartitions}
+	}
+	if !validateOnly {
+		detail.NumPartitions = count
+		m.topics[topic] = detail
+	}
+	return nil
+}
+
+func TestAdminTopicConfig(t *testing.T) {
+	clusterAdmin := newMockClusterAdmin()
+	admin, err := NewAdmin(nil, nil, func(brokers []string, conf *sarama.Config) (sarama.Client, sarama.ClusterAdmin, error) {
+		return mockClient{}, clusterAdmin, nil
+	}, 3, 1)
+	assert.NoError(t, err)
+	_, err = admin.CreateTopic(TopicSpec{Name: "orders", Configs: map[string]string{"cleanup.policy": "compact"}})
+	assert.NoError(t, err)
+
+	retention := "86400000"
+	assert.NoError(t, admin.AlterTopicConfig("orders", map[string]*string{"retention.ms": &retention, "cleanup.policy": nil}, false))
+	configs, err := admin.DescribeTopicConfig("orders")
+	assert.NoError(t, err)
+	assert.Equal(t, []ConfigEntry{
+		{Name: "cleanup.policy", Value: "delete", Source: "Default", Default: true},
+		{Name: "retention.ms", Value: "86400000", Source: "Topic"},
+	}, configs)
+
+	assert.NoError(t, admin.CreatePartitions("orders", 6, true))
+	description, err := admin.DescribeTopic("orders")
+	assert.NoError(t, err)
+	assert.Len(t, description.Partitions, 3, "validating should change nothing")
+	assert.NoError(t, admin.CreatePartitions("orders", 6, false))
+	assert.ErrorIs(t, admin.CreatePartitions("orders", 4, false), sarama.ErrInvalidPartitions)
+}
+
+func TestValidateTopicConfig(t *testing.T) {
+	assert.NoError(t, ValidateTopicConfig("retention.ms", "-1"))
+	assert.NoError(t, ValidateTopicConfig("cleanup.policy", "compact,delete"))
+	assert.NoError(t, ValidateTopicConfig("min.cleanable.dirty.ratio", "0.5"))
+	assert.Error(t, ValidateTopicConfig("retention.ms", "a week"))
+	assert.Error(t, ValidateTopicConfig("retention.ms", "-2"))
+	assert.Error(t, ValidateTopicConfig("cleanup.policy", "compacted"))
+	assert.Error(t, ValidateTopicConfig("max.message.bytes", "4294967296"))
+	assert.Error(t, ValidateTopicConfig("preallocate", "yes"))
+	assert.Error(t, ValidateTopicConfig("retention.mss", "1000"))
+}
+
+func TestAdminAlterTopicConfigAgainstBroker(t *testing.T) {
+	newBroker := func() *sarama.MockBroker {
+		broker := sarama.NewMockBroker(t, 1)
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetController(broker.BrokerID()).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader("test-topic", 0, broker.BrokerID()),
+			"DescribeConfigsRequest":         sarama.NewMockDescribeConfigsResponse(t),
+			"AlterConfigsRequest":            sarama.NewMockAlterConfigsResponse(t),
+			"IncrementalAlterConfigsRequest": sarama.NewMockIncrementalAlterConfigsResponse(t),
+		})
+		return broker
+	}
+	compact := "compact"
+
+	t.Run("incremental by default", func(t *testing.T) {
+		broker := newBroker()
+		defer broker.Close()
+		admin, err := NewAdmin([]string{broker.Addr()}, nil, TheAdminFactory, 1, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer admin.Close()
+
+		assert.NoError(t, admin.AlterTopicConfig("test-topic", map[string]*string{"cleanup.policy": &compact}, false))
+		var sent bool
+		for _, exchange := range broker.History() {
+			_, ok := exchange.Request.(*sarama.IncrementalAlterConfigsRequest)
+			sent = sent || ok
+		}
+		assert.True(t, sent, "no incremental alter configs request sent")
+	})
+
+	t.Run("replaced on brokers before 2.3", func(t *testing.T) {
+		broker := newBroker()
+		defer broker.Close()
+		config := sarama.NewConfig()
+		config.Version = sarama.V2_0_0_0
+		admin, err := NewAdmin([]string{broker.Addr()}, config, TheAdminFactory, 1, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer admin.Close()
+
+		assert.NoError(t, admin.AlterTopicConfig("test-topic", map[string]*string{"cleanup.policy": &compact}, false))
+		var request *sarama.AlterConfigsRequest
+		for _, exchange := range broker.History() {
+			if r, ok := exchange.Request.(*sarama.AlterConfigsRequest); ok {
+				request = r
+			}
+		}
+		// retention.ms is set on the topic and kept, defaults are left out
+		if assert.NotNil(t, request) && assert.Len(t, request.Resources, 1) {
+			entries := request.Resources[0].ConfigEntries
+			assert.Len(t, entries, 2)
+			assert.Equal(t, "5000", *entries["retention.ms"])
+			assert.Equal(t, compact, *entries["cleanup.policy"])
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/Shopify/sarama"
+)
+
+type BrokerInfo struct {
+	ID   int32
+	Addr string
+	Rack string
+}
+
+// PartitionState is a partition of the cluster and where its replicas are
+type PartitionState struct {
+	Topic string
+	PartitionDescription
+}
+
+// ClusterInfo is the state of the cluster as its metadata tells it.
+// Partitions with fewer in-sync replicas than replicas are under-replicated,
+// partitions without a leader are offline.
+type ClusterInfo struct {
+	ControllerID    int32
+	Brokers         []BrokerInfo
+	Topics          int
+	Partitions      int
+	UnderReplicated []PartitionState
+	Offline         []PartitionState
+}
+
+// Ping asks the cluster for its metadata, it fails when Kafka can't be reached
+func (a *Admin) Ping() error {
+	_, _, err := a.admin.DescribeCluster()
+	return err
+}
+
+// DescribeCluster returns the brokers of the cluster and the partitions in trouble
+func (a *Admin) DescribeCluster() (*ClusterInfo, error) {
+	brokers, controllerID, err := a.admin.DescribeCluster()
+	if err != nil {
+		return nil, err
+	}
+	// no topics asks for all of them
+	topics, err := a.admin.DescribeTopics(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ClusterInfo{ControllerID: controllerID}
+	for _, broker := range brokers {
+		info.Brokers = append(info.Brokers, BrokerInfo{ID: broker.ID(), Addr: broker.Addr(), Rack: broker.Rack()})
+	}
+	sort.Slice(info.Brokers, func(i, j int) bool { return info.Brokers[i].ID < info.Brokers[j].ID })
+
+	for _, topic := range topics {
+		if !errors.Is(topic.Err, sarama.ErrNoError) {
+			continue
+		}
+		info.Topics++
+		for _, partition := range topic.Partitions {
+			info.Partitions++
+			state := PartitionState{Topic: topic.Name, PartitionDescription: PartitionDescription{
+				Partition:       partition.ID,
+				Leader:          partition.Leader,
+				Replicas:        partition.Replicas,
+				ISR:             partition.Isr,
+				OfflineReplicas: partition.OfflineReplicas,
+			}}
+			if partition.Leader < 0 {
+				info.Offline = append(info.Offline, state)
+			}
+			if len(partition.Isr) < len(partition.Replicas) {
+				info.UnderReplicated = append(info.UnderReplicated, state)
+			}
+		}
+	}
+	sortPartitionStates(info.UnderReplicated)
+	sortPartitionStates(info.Offline)
+	return info, nil
+}
+
+func sortPartitionStates(states []PartitionState) {
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Topic != states[j].Topic {
+			return states[i].Topic < states[j].Topic
+		}
+		return states[i].Partition < states[j].Partition
+	})
+}
@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockClusterStateAdmin describes a cluster of three brokers
+type mockClusterStateAdmin struct {
+	sarama.ClusterAdmin
+	topics []*sarama.TopicMetadata
+}
+
+func (m *mockClusterStateAdmin) DescribeCluster() ([]*sarama.Broker, int32, error) {
+	return []*sarama.Broker{sarama.NewBroker("kafka-1:9092"), sarama.NewBroker("kafka-2:9092"), sarama.NewBroker("kafka-3:9092")}, 2, nil
+}
+
+func (m *mockClusterStateAdmin) DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error) {
+	return m.topics, nil
+}
+
+func TestAdminDescribeCluster(t *testing.T) {
+	clusterAdmin := &mockClusterStateAdmin{topics: []*sarama.TopicMetadata{
+		{Name: "orders", Partitions: []*sarama.PartitionMetadata{
+			{ID: 1, Leader: 2, Replicas: []int32{2, 3}, Isr: []int32{2}, OfflineReplicas: []int32{3}},
+			{ID: 0, Leader: 1, Replicas: []int32{1, 2}, Isr: []int32{1, 2}},
+		}},
+		{Name: "payments", Partitions: []*sarama.PartitionMetadata{
+			{ID: 0, Leader: -1, Replicas: []int32{3}, Isr: []int32{}, OfflineReplicas: []int32{3}},
+		}},
+		{Name: "deleted", Err: sarama.ErrUnknownTopicOrPartition},
+	}}
+	admin, err := NewAdmin(nil, nil, func(brokers []string, conf *sarama.Config) (sarama.Client, sarama.ClusterAdmin, error) {
+		return mockClient{}, clusterAdmin, nil
+	}, 1, 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, admin.Ping())
+	info, err := admin.DescribeCluster()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), info.ControllerID)
+	assert.Len(t, info.Brokers, 3)
+	assert.Equal(t, 2, info.Topics)
+	assert.Equal(t, 3, info.Partitions)
+
+	assert.Len(t, info.UnderReplicated, 2)
+	assert.Equal(t, "orders", info.UnderReplicated[0].Topic)
+	assert.Equal(t, int32(1), info.UnderReplicated[0].Partition)
+	assert.Equal(t, []int32{3}, info.UnderReplicated[0].OfflineReplicas)
+	assert.Len(t, info.Offline, 1)
+	assert.Equal(t, "payments", info.Offline[0].Topic)
+}
@@ -0,0 +1,797 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInstanceNotFound     = errors.New("consumer instance not found")
+	ErrInstanceClosed       = errors.New("consumer instance is closed")
+	ErrNotSubscribed        = errors.New("consumer instance is not subscribed to any topic")
+	ErrPartitionNotAssigned = errors.New("partition is not assigned to the consumer instance")
+	ErrCommitFailed         = errors.New("offsets were not committed")
+	ErrGroupHasInstance     = errors.New("consumer group already has an instance, only one instance per group is supported")
+)
+
+type ConsumerFactory func(brokers []string, conf *sarama.Config) (sarama.Client, sarama.Consumer, error)
+
+type OffsetManagerFactory func(group string, client sarama.Client) (sarama.OffsetManager, error)
+
+// OffsetFetcher reads the offsets the group stored on the broker, -1 for partitions without one
+type OffsetFetcher func(client sarama.Client, group string, partitions []TopicPartition) (map[TopicPartition]int64, error)
+
+// Record is a message read back from a Kafka topic
+type Record struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+	// Headers holds the record headers, the last value wins for repeated keys
+	Headers map[string]string
+}
+
+func newRecord(msg *sarama.ConsumerMessage) *Record {
+	record := &Record{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Timestamp: msg.Timestamp,
+	}
+	if len(msg.Headers) > 0 {
+		record.Headers = make(map[string]string, len(msg.Headers))
+		for _, header := range msg.Headers {
+			if header != nil {
+				record.Headers[string(header.Key)] = string(header.Value)
+			}
+		}
+	}
+	return record
+}
+
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+// PartitionOffset is an offset of a single partition. For commits the offset
+// is the next one to read, i.e. the offset of the last processed record plus one.
+type PartitionOffset struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// InstanceOptions holds the settings of a new consumer instance
+type InstanceOptions struct {
+	// FromBeginning starts partitions without a committed offset at the oldest offset instead of the newest
+	FromBeginning bool
+	// AutoCommit commits the records returned by a Poll when the next Poll happens
+	AutoCommit bool
+}
+
+// Consumer keeps track of the consumer instances created over the REST API
+type Consumer struct {
+	brokers        []string
+	config         *sarama.Config
+	factory        ConsumerFactory
+	offsetManagers OffsetManagerFactory
+	fetchOffsets   OffsetFetcher
+	instances      map[string]*ConsumerInstance
+	mutex          *sync.RWMutex
+}
+
+func NewConsumer(brokers []string, conf *sarama.Config, factory ConsumerFactory) *Consumer {
+	var config *sarama.Config
+	if conf == nil {
+		config = sarama.NewConfig()
+	} else {
+		config = conf
+	}
+
+	// set client id if not set
+	if config.ClientID == "" {
+		config.ClientID = "kafka-go"
+	}
+	// instances decide themselves when offsets are committed
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	return &Consumer{
+		brokers:        brokers,
+		config:         config,
+		factory:        factory,
+		offsetManagers: sarama.NewOffsetManagerFromClient,
+		fetchOffsets:   FetchCommittedOffsets,
+		instances:      make(map[string]*ConsumerInstance),
+		mutex:          &sync.RWMutex{},
+	}
+}
+
+// CreateInstance starts a new consumer instance in the given group.
+// The instance is only visible to the owner that created it. Instances don't
+// join the Kafka consumer group, they read every partition and commit on
+// their own, so a group gets at most one instance, a second is refused with
+// ErrGroupHasInstance.
+func (c *Consumer) CreateInstance(group string, owner string, opts InstanceOptions) (*ConsumerInstance, error) {
+	c.mutex.RLock()
+	taken := c.groupHasInstance(group)
+	c.mutex.RUnlock()
+	if taken {
+		return nil, ErrGroupHasInstance
+	}
+
+	client, consumer, err := c.factory(c.brokers, c.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start consumer: %w", err)
+	}
+
+	offsets, err := c.offsetManagers(group, client)
+	if err != nil {
+		_ = consumer.Close()
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to start offset manager: %w", err)
+	}
+
+	initialOffset := sarama.OffsetNewest
+	if opts.FromBeginning {
+		initialOffset = sarama.OffsetOldest
+	}
+
+	instance := &ConsumerInstance{
+		ID:            uuid.New().String(),
+		Group:         group,
+		Owner:         owner,
+		client:        client,
+		consumer:      consumer,
+		offsets:       offsets,
+		newOffsets:    c.offsetManagers,
+		fetchOffsets:  c.fetchOffsets,
+		initialOffset: initialOffset,
+		autoCommit:    opts.AutoCommit,
+		partitions:    make(map[TopicPartition]*partitionReader),
+		records:       make(chan fetchedMessage, c.config.ChannelBufferSize),
+		closing:       make(chan struct{}),
+	}
+
+	c.mutex.Lock()
+	// another instance may have been created in the group meanwhile
+	if c.groupHasInstance(group) {
+		c.mutex.Unlock()
+		if err := instance.Close(); err != nil {
+			log.Printf("failed to close consumer instance %s: %v", instance.ID, err)
+		}
+		return nil, ErrGroupHasInstance
+	}
+	c.instances[instance.ID] = instance
+	c.mutex.Unlock()
+
+	log.Printf("consumer instance %s created in group %s", instance.ID, group)
+	return instance, nil
+}
+
+// groupHasInstance must be called with c.mutex held
+func (c *Consumer) groupHasInstance(group string) bool {
+	for _, instance := range c.instances {
+		if instance.Group == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Instance returns the consumer instance with the given id if it belongs to the owner
+func (c *Consumer) Instance(id string, owner string) (*ConsumerInstance, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	instance, ok := c.instances[id]
+	if !ok || instance.Owner != owner {
+		return nil, ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+// DeleteInstance closes the consumer instance and forgets about it
+func (c *Consumer) DeleteInstance(id string, owner string) error {
+	c.mutex.Lock()
+	instance, ok := c.instances[id]
+	if !ok || instance.Owner != owner {
+		c.mutex.Unlock()
+		return ErrInstanceNotFound
+	}
+	delete(c.instances, id)
+	c.mutex.Unlock()
+
+	log.Printf("consumer instance %s deleted", id)
+	return instance.Close()
+}
+
+func (c *Consumer) Close() error {
+	c.mutex.Lock()
+	instances := c.instances
+	c.instances = make(map[string]*ConsumerInstance)
+	c.mutex.Unlock()
+
+	for _, instance := range instances {
+		if err := instance.Close(); err != nil {
+			log.Printf("failed to close consumer instance %s: %v", instance.ID, err)
+		}
+	}
+	return nil
+}
+
+// partitionReader forwards the messages of one partition consumer to the
+// instance buffer. A seek replaces the reader, which is how messages that
+// were buffered before the seek are recognised and dropped.
+type partitionReader struct {
+	consumer sarama.PartitionConsumer
+	offsets  sarama.PartitionOffsetManager
+	// position is the next offset Poll will hand out, -1 until the first record
+	position int64
+	stopped  chan struct{}
+}
+
+type fetchedMessage struct {
+	message *sarama.ConsumerMessage
+	reader  *partitionReader
+}
+
+// ConsumerInstance reads every partition of the topics it is subscribed to
+// and buffers the messages until they are fetched with Poll.
+type ConsumerInstance struct {
+	ID    string
+	Group string
+	Owner string
+
+	client        sarama.Client
+	consumer      sarama.Consumer
+	offsets       sarama.OffsetManager
+	newOffsets    OffsetManagerFactory
+	fetchOffsets  OffsetFetcher
+	initialOffset int64
+	autoCommit    bool
+	topics        []string
+	partitions    map[TopicPartition]*partitionReader
+	records       chan fetchedMessage
+	closing       chan struct{}
+	closed        bool
+	mutex         sync.Mutex
+	pollMutex     sync.Mutex
+	wg            sync.WaitGroup
+}
+
+// Subscribe replaces the current subscription with the given topics
+func (ci *ConsumerInstance) Subscribe(topics []string) error {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	if ci.closed {
+		return ErrInstanceClosed
+	}
+
+	// resolve every topic first so a bad topic leaves the old subscription intact
+	partitions := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		ids, err := ci.consumer.Partitions(topic)
+		if err != nil {
+			return fmt.Errorf("failed to get partitions for topic %s: %w", topic, err)
+		}
+		partitions[topic] = ids
+	}
+
+	ci.stopPartitions()
+	for topic, ids := range partitions {
+		for _, partition := range ids {
+			if err := ci.startPartition(TopicPartition{Topic: topic, Partition: partition}); err != nil {
+				ci.stopPartitions()
+				ci.topics = nil
+				return err
+			}
+		}
+	}
+
+	ci.topics = append([]string(nil), topics...)
+	sort.Strings(ci.topics)
+	return nil
+}
+
+// Subscription returns the topics the instance is subscribed to
+func (ci *ConsumerInstance) Subscription() []string {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	return append([]string(nil), ci.topics...)
+}
+
+// Unsubscribe stops reading from every topic
+func (ci *ConsumerInstance) Unsubscribe() {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	ci.stopPartitions()
+	ci.topics = nil
+}
+
+// Poll waits up to timeout for records and returns at most maxRecords of them.
+// It returns as soon as at least one record is available. With auto-commit
+// enabled the records returned by the previous Poll are committed first.
+func (ci *ConsumerInstance) Poll(timeout time.Duration, maxRecords int) ([]*Record, error) {
+	ci.pollMutex.Lock()
+	defer ci.pollMutex.Unlock()
+
+	ci.mutex.Lock()
+	closed, subscribed := ci.closed, len(ci.topics) > 0
+	if !closed && ci.autoCommit {
+		if _, err := ci.commitPositions(); err != nil {
+			log.Printf("consumer instance %s failed to auto-commit: %v", ci.ID, err)
+		}
+	}
+	ci.mutex.Unlock()
+	if closed {
+		return nil, ErrInstanceClosed
+	}
+	if !subscribed {
+		return nil, ErrNotSubscribed
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	records := make([]*Record, 0)
+	for len(records) < maxRecords {
+		var fetched fetchedMessage
+		if len(records) > 0 {
+			// we already have something to return, only take what is buffered
+			select {
+			case fetched = <-ci.records:
+			default:
+				return records, nil
+			}
+		} else {
+			select {
+			case fetched = <-ci.records:
+			case <-timer.C:
+				return records, nil
+			case <-ci.closing:
+				return records, ErrInstanceClosed
+			}
+		}
+
+		if record := ci.accept(fetched); record != nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// accept turns a buffered message into a record unless it was read before a seek
+func (ci *ConsumerInstance) accept(fetched fetchedMessage) *Record {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	tp := TopicPartition{Topic: fetched.message.Topic, Partition: fetched.message.Partition}
+	if ci.partitions[tp] != fetched.reader {
+		return nil
+	}
+	fetched.reader.position = fetched.message.Offset + 1
+	return newRecord(fetched.message)
+}
+
+// SetAutoCommit turns auto-commit on or off
+func (ci *ConsumerInstance) SetAutoCommit(enabled bool) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	ci.autoCommit = enabled
+}
+
+func (ci *ConsumerInstance) AutoCommit() bool {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	return ci.autoCommit
+}
+
+// Commit commits the given offsets for the instance's group. Without offsets
+// it commits everything returned by Poll so far. ErrCommitFailed means the
+// broker didn't store them.
+func (ci *ConsumerInstance) Commit(offsets []PartitionOffset) ([]PartitionOffset, error) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	if ci.closed {
+		return nil, ErrInstanceClosed
+	}
+
+	if len(offsets) == 0 {
+		return ci.commitPositions()
+	}
+
+	// validate everything before marking anything
+	for _, offset := range offsets {
+		if _, ok := ci.partitions[TopicPartition{Topic: offset.Topic, Partition: offset.Partition}]; !ok {
+			return nil, fmt.Errorf("%s/%d: %w", offset.Topic, offset.Partition, ErrPartitionNotAssigned)
+		}
+		if offset.Offset < 0 {
+			return nil, fmt.Errorf("invalid offset %d for %s/%d", offset.Offset, offset.Topic, offset.Partition)
+		}
+	}
+	for _, offset := range offsets {
+		reader := ci.partitions[TopicPartition{Topic: offset.Topic, Partition: offset.Partition}]
+		// MarkOffset only moves forward and ResetOffset only moves back,
+		// together they set the offset to exactly what was asked for
+		reader.offsets.MarkOffset(offset.Offset, "")
+		reader.offsets.ResetOffset(offset.Offset, "")
+	}
+	if err := ci.commit(offsets); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// Committed returns the offsets the broker stored for the group on every
+// assigned partition, partitions without one are left out
+func (ci *ConsumerInstance) Committed() ([]PartitionOffset, error) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	if ci.closed {
+		return nil, ErrInstanceClosed
+	}
+	offsets := make([]PartitionOffset, 0, len(ci.partitions))
+	if len(ci.partitions) == 0 {
+		return offsets, nil
+	}
+
+	partitions := make([]TopicPartition, 0, len(ci.partitions))
+	for tp := range ci.partitions {
+		partitions = append(partitions, tp)
+	}
+	stored, err := ci.fetchOffsets(ci.client, ci.Group, partitions)
+	if err != nil {
+		return nil, err
+	}
+	for tp, offset := range stored {
+		if offset < 0 {
+			continue
+		}
+		offsets = append(offsets, PartitionOffset{Topic: tp.Topic, Partition: tp.Partition, Offset: offset})
+	}
+	sortOffsets(offsets)
+	return offsets, nil
+}
+
+// Seek moves the given partitions to absolute offsets
+func (ci *ConsumerInstance) Seek(offsets []PartitionOffset) error {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	for _, offset := range offsets {
+		if offset.Offset < 0 {
+			return fmt.Errorf("invalid offset %d for %s/%d", offset.Offset, offset.Topic, offset.Partition)
+		}
+	}
+	return ci.seek(offsets)
+}
+
+// SeekToBeginning moves the given partitions, or all assigned partitions when
+// none are given, to the oldest available offset
+func (ci *ConsumerInstance) SeekToBeginning(partitions []TopicPartition) error {
+	return ci.seekTo(partitions, sarama.OffsetOldest)
+}
+
+// SeekToEnd moves the given partitions, or all assigned partitions when none
+// are given, past the newest offset so only new records are read
+func (ci *ConsumerInstance) SeekToEnd(partitions []TopicPartition) error {
+	return ci.seekTo(partitions, sarama.OffsetNewest)
+}
+
+// SeekToTimestamp moves the given partitions to the first offset whose
+// timestamp is at or after ts. Partitions without such a record move to the end.
+func (ci *ConsumerInstance) SeekToTimestamp(partitions []TopicPartition, ts time.Time) error {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	if ci.closed {
+		return ErrInstanceClosed
+	}
+
+	offsets := make([]PartitionOffset, 0, len(partitions))
+	for _, tp := range ci.resolve(partitions) {
+		offset, err := ci.client.GetOffset(tp.Topic, tp.Partition, ts.UnixMilli())
+		if err != nil {
+			return fmt.Errorf("failed to look up offset for %s/%d: %w", tp.Topic, tp.Partition, err)
+		}
+		if offset < 0 {
+			offset = sarama.OffsetNewest
+		}
+		offsets = append(offsets, PartitionOffset{Topic: tp.Topic, Partition: tp.Partition, Offset: offset})
+	}
+	return ci.seek(offsets)
+}
+
+func (ci *ConsumerInstance) seekTo(partitions []TopicPartition, target int64) error {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	resolved := ci.resolve(partitions)
+	offsets := make([]PartitionOffset, 0, len(resolved))
+	for _, tp := range resolved {
+		offsets = append(offsets, PartitionOffset{Topic: tp.Topic, Partition: tp.Partition, Offset: target})
+	}
+	return ci.seek(offsets)
+}
+
+// resolve must be called with ci.mutex held
+func (ci *ConsumerInstance) resolve(partitions []TopicPartition) []TopicPartition {
+	if len(partitions) > 0 {
+		return partitions
+	}
+	resolved := make([]TopicPartition, 0, len(ci.partitions))
+	for tp := range ci.partitions {
+		resolved = append(resolved, tp)
+	}
+	return resolved
+}
+
+// seek must be called with ci.mutex held
+func (ci *ConsumerInstance) seek(offsets []PartitionOffset) error {
+	if ci.closed {
+		return ErrInstanceClosed
+	}
+	if len(ci.topics) == 0 {
+		return ErrNotSubscribed
+	}
+	for _, offset := range offsets {
+		if _, ok := ci.partitions[TopicPartition{Topic: offset.Topic, Partition: offset.Partition}]; !ok {
+			return fmt.Errorf("%s/%d: %w", offset.Topic, offset.Partition, ErrPartitionNotAssigned)
+		}
+	}
+
+	for _, offset := range offsets {
+		tp := TopicPartition{Topic: offset.Topic, Partition: offset.Partition}
+		old := ci.partitions[tp]
+		close(old.stopped)
+		// sarama refuses to consume a partition again until its old consumer is gone
+		if err := old.consumer.Close(); err != nil {
+			log.Printf("failed to close consumer of %s/%d: %v", tp.Topic, tp.Partition, err)
+		}
+
+		pc, err := ci.consumer.ConsumePartition(tp.Topic, tp.Partition, offset.Offset)
+		if err != nil {
+			// carry on where the partition was, the assignment stays as it is
+			resumed, resumeErr := ci.consumer.ConsumePartition(tp.Topic, tp.Partition, ci.resumeOffset(old))
+			if resumeErr != nil {
+				delete(ci.partitions, tp)
+				old.offsets.AsyncClose()
+				return fmt.Errorf("failed to seek %s/%d, it is no longer read: %w", tp.Topic, tp.Partition, err)
+			}
+			ci.forward(tp, &partitionReader{consumer: resumed, offsets: old.offsets, position: old.position, stopped: make(chan struct{})})
+			return fmt.Errorf("failed to seek %s/%d: %w", tp.Topic, tp.Partition, err)
+		}
+		ci.forward(tp, &partitionReader{consumer: pc, offsets: old.offsets, position: -1, stopped: make(chan struct{})})
+		log.Printf("consumer instance %s moved %s/%d to offset %d", ci.ID, tp.Topic, tp.Partition, offset.Offset)
+	}
+	return nil
+}
+
+// resumeOffset is where a reader that was stopped carries on: after the last
+// record returned by Poll, or else at the committed offset
+func (ci *ConsumerInstance) resumeOffset(reader *partitionReader) int64 {
+	if reader.position >= 0 {
+		return reader.position
+	}
+	offset, _ := reader.offsets.NextOffset()
+	if offset < 0 {
+		return ci.initialOffset
+	}
+	return offset
+}
+
+// Close stops every partition consumer, committing what was returned by
+// Poll when auto-commit is on, and releases the sarama client
+func (ci *ConsumerInstance) Close() error {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
+	if ci.closed {
+		return nil
+	}
+	ci.closed = true
+	close(ci.closing)
+	ci.stopPartitions()
+
+	if err := ci.consumer.Close(); err != nil {
+		return fmt.Errorf("failed to close consumer: %w", err)
+	}
+	if err := ci.client.Close(); err != nil {
+		return fmt.Errorf("failed to close client: %w", err)
+	}
+	return nil
+}
+
+// commitPositions must be called with ci.mutex held
+func (ci *ConsumerInstance) commitPositions() ([]PartitionOffset, error) {
+	offsets := make([]PartitionOffset, 0, len(ci.partitions))
+	for tp, reader := range ci.partitions {
+		if reader.position < 0 {
+			continue
+		}
+		reader.offsets.MarkOffset(reader.position, "")
+		offsets = append(offsets, PartitionOffset{Topic: tp.Topic, Partition: tp.Partition, Offset: reader.position})
+	}
+	sortOffsets(offsets)
+	if len(offsets) > 0 {
+		if err := ci.commit(offsets); err != nil {
+			return nil, err
+		}
+	}
+	return offsets, nil
+}
+
+// commit sends the marked offsets and reads them back, the offset manager
+// only reports failed commits on channels nobody reads. It must be called
+// with ci.mutex held.
+func (ci *ConsumerInstance) commit(offsets []PartitionOffset) error {
+	ci.offsets.Commit()
+
+	partitions := make([]TopicPartition, len(offsets))
+	for i, offset := range offsets {
+		partitions[i] = TopicPartition{Topic: offset.Topic, Partition: offset.Partition}
+	}
+	stored, err := ci.fetchOffsets(ci.client, ci.Group, partitions)
+	if err != nil {
+		return fmt.Errorf("failed to read back committed offsets: %w", err)
+	}
+	var missed []string
+	for _, offset := range offsets {
+		if stored[TopicPartition{Topic: offset.Topic, Partition: offset.Partition}] != offset.Offset {
+			missed = append(missed, fmt.Sprintf("%s/%d", offset.Topic, offset.Partition))
+		}
+	}
+	if len(missed) > 0 {
+		return fmt.Errorf("%w: %s", ErrCommitFailed, strings.Join(missed, ", "))
+	}
+	return nil
+}
+
+// FetchCommittedOffsets asks the group coordinator for the offsets the group stored
+func FetchCommittedOffsets(client sarama.Client, group string, partitions []TopicPartition) (map[TopicPartition]int64, error) {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return nil, err
+	}
+	// version 1 reads the offsets stored in Kafka, like the offset manager commits them
+	request := &sarama.OffsetFetchRequest{Version: 1, ConsumerGroup: group}
+	for _, tp := range partitions {
+		request.AddPartition(tp.Topic, tp.Partition)
+	}
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[TopicPartition]int64, len(partitions))
+	for _, tp := range partitions {
+		block := response.GetBlock(tp.Topic, tp.Partition)
+		if block == nil {
+			return nil, fmt.Errorf("%s/%d: %w", tp.Topic, tp.Partition, sarama.ErrIncompleteResponse)
+		}
+		if !errors.Is(block.Err, sarama.ErrNoError) {
+			return nil, fmt.Errorf("%s/%d: %w", tp.Topic, tp.Partition, block.Err)
+		}
+		offsets[tp] = block.Offset
+	}
+	return offsets, nil
+}
+
+// startPartition must be called with ci.mutex held
+func (ci *ConsumerInstance) startPartition(tp TopicPartition) error {
+	if ci.offsets == nil {
+		offsets, err := ci.newOffsets(ci.Group, ci.client)
+		if err != nil {
+			return fmt.Errorf("failed to start offset manager: %w", err)
+		}
+		ci.offsets = offsets
+	}
+	pom, err := ci.offsets.ManagePartition(tp.Topic, tp.Partition)
+	if err != nil {
+		return fmt.Errorf("failed to manage offsets for %s/%d: %w", tp.Topic, tp.Partition, err)
+	}
+
+	// negative means the group has nothing committed for this partition
+	offset, _ := pom.NextOffset()
+	if offset < 0 {
+		offset = ci.initialOffset
+	}
+
+	pc, err := ci.consumer.ConsumePartition(tp.Topic, tp.Partition, offset)
+	if err != nil {
+		pom.AsyncClose()
+		return fmt.Errorf("failed to consume %s/%d: %w", tp.Topic, tp.Partition, err)
+	}
+
+	ci.forward(tp, &partitionReader{consumer: pc, offsets: pom, position: -1, stopped: make(chan struct{})})
+	return nil
+}
+
+// forward must be called with ci.mutex held
+func (ci *ConsumerInstance) forward(tp TopicPartition, reader *partitionReader) {
+	ci.partitions[tp] = reader
+
+	ci.wg.Add(1)
+	go func() {
+		defer ci.wg.Done()
+		for msg := range reader.consumer.Messages() {
+			select {
+			case ci.records <- fetchedMessage{message: msg, reader: reader}:
+			case <-reader.stopped:
+				return
+			case <-ci.closing:
+				return
+			}
+		}
+	}()
+}
+
+// stopPartitions must be called with ci.mutex held
+func (ci *ConsumerInstance) stopPartitions() {
+	if ci.autoCommit {
+		if _, err := ci.commitPositions(); err != nil {
+			log.Printf("consumer instance %s failed to commit before stopping: %v", ci.ID, err)
+		}
+	}
+	for tp, reader := range ci.partitions {
+		close(reader.stopped)
+		if err := reader.consumer.Close(); err != nil {
+			log.Printf("failed to close consumer of %s/%d: %v", tp.Topic, tp.Partition, err)
+		}
+		log.Printf("consumer instance %s stopped reading %s/%d", ci.ID, tp.Topic, tp.Partition)
+	}
+	ci.partitions = make(map[TopicPartition]*partitionReader)
+
+	// without auto-commit in sarama, partition offset managers are only released
+	// by a commit or by closing their offset manager, closing one would block.
+	// Closing the offset manager drops them all, startPartition makes a new one.
+	if ci.offsets != nil {
+		if err := ci.offsets.Close(); err != nil {
+			log.Printf("failed to close offset manager of consumer instance %s: %v", ci.ID, err)
+		}
+		ci.offsets = nil
+	}
+
+	// throw away whatever the old subscription left behind
+	done := make(chan struct{})
+	go func() {
+		ci.wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-ci.records:
+		case <-done:
+			return
+		}
+	}
+}
+
+func sortOffsets(offsets []PartitionOffset) {
+	sort.Slice(offsets, func(i, j int) bool {
+		if offsets[i].Topic != offsets[j].Topic {
+			return offsets[i].Topic < offsets[j].Topic
+		}
+		return offsets[i].Partition < offsets[j].Partition
+	})
+}
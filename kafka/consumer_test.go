@@ -0,0 +1,305 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockClient stands in for the sarama client, only Close is ever called on it
+type mockClient struct {
+	sarama.Client
+}
+
+func (mockClient) Close() error {
+	return nil
+}
+
+// mockOffsetManager keeps offsets in memory and counts commits, stored is
+// what the broker holds after the commits that didn't fail
+type mockOffsetManager struct {
+	partitions  map[TopicPartition]*mockPartitionOffsetManager
+	stored      map[TopicPartition]int64
+	commits     int
+	failCommits bool
+}
+
+func (om *mockOffsetManager) ManagePartition(topic string, partition int32) (sarama.PartitionOffsetManager, error) {
+	tp := TopicPartition{Topic: topic, Partition: partition}
+	if om.partitions[tp] == nil {
+		om.partitions[tp] = &mockPartitionOffsetManager{offset: -1}
+	}
+	return om.partitions[tp], nil
+}
+
+func (om *mockOffsetManager) Commit() {
+	om.commits++
+	if om.failCommits {
+		return
+	}
+	for tp, pom := range om.partitions {
+		om.stored[tp] = pom.offset
+	}
+}
+
+func (om *mockOffsetManager) fetch(client sarama.Client, group string, partitions []TopicPartition) (map[TopicPartition]int64, error) {
+	offsets := make(map[TopicPartition]int64, len(partitions))
+	for _, tp := range partitions {
+		offset, ok := om.stored[tp]
+		if !ok {
+			offset = -1
+		}
+		offsets[tp] = offset
+	}
+	return offsets, nil
+}
+
+func (om *mockOffsetManager) Close() error {
+	return nil
+}
+
+type mockPartitionOffsetManager struct {
+	sarama.PartitionOffsetManager
+	offset int64
+}
+
+func (pom *mockPartitionOffsetManager) NextOffset() (int64, string) {
+	return pom.offset, ""
+}
+
+func (pom *mockPartitionOffsetManager) MarkOffset(offset int64, metadata string) {
+	if offset > pom.offset {
+		pom.offset = offset
+	}
+}
+
+func (pom *mockPartitionOffsetManager) ResetOffset(offset int64, metadata string) {
+	if offset <= pom.offset {
+		pom.offset = offset
+	}
+}
+
+func (pom *mockPartitionOffsetManager) AsyncClose() {}
+
+func (pom *mockPartitionOffsetManager) Close() error {
+	return nil
+}
+
+func newMockConsumer(t *testing.T, consumer *mocks.Consumer, offsets *mockOffsetManager) *Consumer {
+	factory := func(brokers []string, config *sarama.Config) (sarama.Client, sarama.Consumer, error) {
+		return mockClient{}, consumer, nil
+	}
+	c := NewConsumer(nil, mocks.NewTestConfig(), factory)
+	c.offsetManagers = func(group string, client sarama.Client) (sarama.OffsetManager, error) {
+		return offsets, nil
+	}
+	c.fetchOffsets = offsets.fetch
+	return c
+}
+
+func newMockOffsetManager() *mockOffsetManager {
+	return &mockOffsetManager{
+		partitions: make(map[TopicPartition]*mockPartitionOffsetManager),
+		stored:     make(map[TopicPartition]int64),
+	}
+}
+
+func TestConsumerInstancePoll(t *testing.T) {
+	// Given
+	mockConsumer := mocks.NewConsumer(t, nil)
+	mockConsumer.SetTopicMetadata(map[string][]int32{"test-topic": {0}})
+	mockConsumer.ExpectConsumePartition("test-topic", 0, sarama.OffsetOldest).
+		YieldMessage(&sarama.ConsumerMessage{Key: []byte("test-key"), Value: []byte("test-value")})
+
+	consumer := newMockConsumer(t, mockConsumer, newMockOffsetManager())
+	instance, err := consumer.CreateInstance("test-group", "owner", InstanceOptions{FromBeginning: true})
+	assert.NoError(t, err)
+
+	// When
+	assert.NoError(t, instance.Subscribe([]string{"test-topic"}))
+	records, err := instance.Poll(time.Second, 10)
+
+	// Then
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "test-topic", records[0].Topic)
+		assert.Equal(t, int64(0), records[0].Offset)
+		assert.Equal(t, "test-key", string(records[0].Key))
+		assert.Equal(t, "test-value", string(records[0].Value))
+	}
+	assert.Equal(t, []string{"test-topic"}, instance.Subscription())
+
+	// an empty poll returns once the timeout expires
+	records, err = instance.Poll(10*time.Millisecond, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	assert.NoError(t, consumer.DeleteInstance(instance.ID, "owner"))
+}
+
+func TestConsumerInstanceOwnership(t *testing.T) {
+	consumer := newMockConsumer(t, mocks.NewConsumer(t, nil), newMockOffsetManager())
+	instance, err := consumer.CreateInstance("test-group", "owner", InstanceOptions{})
+	assert.NoError(t, err)
+
+	_, err = consumer.Instance(instance.ID, "someone-else")
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+	assert.ErrorIs(t, consumer.DeleteInstance(instance.ID, "someone-else"), ErrInstanceNotFound)
+
+	_, err = instance.Poll(time.Millisecond, 1)
+	assert.ErrorIs(t, err, ErrNotSubscribed)
+
+	// instances don't join the group, a second one would read the same records
+	_, err = consumer.CreateInstance("test-group", "someone-else", InstanceOptions{})
+	assert.ErrorIs(t, err, ErrGroupHasInstance)
+
+	assert.NoError(t, consumer.DeleteInstance(instance.ID, "owner"))
+	_, err = consumer.Instance(instance.ID, "owner")
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+
+	instance, err = consumer.CreateInstance("test-group", "someone-else", InstanceOptions{})
+	assert.NoError(t, err, "the group is free again once its instance is deleted")
+	assert.NoError(t, consumer.DeleteInstance(instance.ID, "someone-else"))
+}
+
+func TestConsumerInstanceCommit(t *testing.T) {
+	// Given
+	offsets := newMockOffsetManager()
+	// the group already committed offset 5, consumption resumes there
+	offsets.partitions[TopicPartition{Topic: "test-topic", Partition: 0}] = &mockPartitionOffsetManager{offset: 5}
+	offsets.stored[TopicPartition{Topic: "test-topic", Partition: 0}] = 5
+
+	mockConsumer := mocks.NewConsumer(t, nil)
+	mockConsumer.SetTopicMetadata(map[string][]int32{"test-topic": {0}})
+	mockConsumer.ExpectConsumePartition("test-topic", 0, 5).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("first")}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("second")})
+
+	consumer := newMockConsumer(t, mockConsumer, offsets)
+	instance, err := consumer.CreateInstance("test-group", "owner", InstanceOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, instance.Subscribe([]string{"test-topic"}))
+
+	// When
+	records, err := instance.Poll(time.Second, 1)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	committed, err := instance.Commit(nil)
+
+	// Then the position after the returned record is committed
+	assert.NoError(t, err)
+	assert.Equal(t, []PartitionOffset{{Topic: "test-topic", Partition: 0, Offset: 6}}, committed)
+	stored, err := instance.Committed()
+	assert.NoError(t, err)
+	assert.Equal(t, committed, stored)
+	assert.Equal(t, 1, offsets.commits)
+
+	// explicit offsets may move the committed offset back
+	_, err = instance.Commit([]PartitionOffset{{Topic: "test-topic", Partition: 0, Offset: 2}})
+	assert.NoError(t, err)
+	stored, err = instance.Committed()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), stored[0].Offset)
+
+	// a commit the broker didn't store is reported
+	offsets.failCommits = true
+	_, err = instance.Commit([]PartitionOffset{{Topic: "test-topic", Partition: 0, Offset: 4}})
+	assert.ErrorIs(t, err, ErrCommitFailed)
+	stored, err = instance.Committed()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), stored[0].Offset)
+	offsets.failCommits = false
+
+	_, err = instance.Commit([]PartitionOffset{{Topic: "other-topic", Partition: 0, Offset: 2}})
+	assert.ErrorIs(t, err, ErrPartitionNotAssigned)
+
+	// with auto-commit the next poll commits what the previous one returned
+	instance.SetAutoCommit(true)
+	records, err = instance.Poll(time.Second, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(records[0].Value))
+	_, err = instance.Poll(time.Millisecond, 1)
+	assert.NoError(t, err)
+	stored, err = instance.Committed()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), stored[0].Offset)
+
+	assert.NoError(t, consumer.DeleteInstance(instance.ID, "owner"))
+}
+
+// TestConsumerInstanceAgainstBroker runs an instance on sarama's real consumer
+// and offset manager, which the mocks don't behave like when partitions move
+func TestConsumerInstanceAgainstBroker(t *testing.T) {
+	// Given
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("test-topic", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("test-topic", 0, sarama.OffsetOldest, 0).
+			SetOffset("test-topic", 0, sarama.OffsetNewest, 3),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage("test-topic", 0, 0, sarama.StringEncoder("first")).
+			SetMessage("test-topic", 0, 1, sarama.StringEncoder("second")).
+			SetMessage("test-topic", 0, 2, sarama.StringEncoder("third")).
+			SetHighWaterMark("test-topic", 0, 3),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "test-group", broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("test-group", "test-topic", 0, -1, "", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	config := sarama.NewConfig()
+	config.Metadata.Retry.Max = 0
+	consumer := NewConsumer([]string{broker.Addr()}, config, TheConsumerFactory)
+	instance, err := consumer.CreateInstance("test-group", "owner", InstanceOptions{FromBeginning: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// When
+		assert.NoError(t, instance.Subscribe([]string{"test-topic"}))
+		records, err := instance.Poll(5*time.Second, 3)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, records)
+
+		// Then seeking consumes the partition again from the new offset
+		assert.NoError(t, instance.Seek([]PartitionOffset{{Topic: "test-topic", Partition: 0, Offset: 2}}))
+		records, err = instance.Poll(5*time.Second, 1)
+		assert.NoError(t, err)
+		if assert.Len(t, records, 1) {
+			assert.Equal(t, int64(2), records[0].Offset)
+			assert.Equal(t, "third", string(records[0].Value))
+		}
+
+		// a seek out of range keeps the partition assigned
+		assert.Error(t, instance.Seek([]PartitionOffset{{Topic: "test-topic", Partition: 0, Offset: 100}}))
+		assert.NoError(t, instance.SeekToBeginning(nil))
+		records, err = instance.Poll(5*time.Second, 1)
+		assert.NoError(t, err)
+		if assert.Len(t, records, 1) {
+			assert.Equal(t, int64(0), records[0].Offset)
+		}
+
+		// stopping partitions doesn't wait on their offset managers
+		instance.Unsubscribe()
+		assert.NoError(t, instance.Subscribe([]string{"test-topic"}))
+		assert.NoError(t, consumer.DeleteInstance(instance.ID, "owner"))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("consumer instance hung")
+	}
+}
@@ -2,12 +2,17 @@ package kafka
 
 import "github.com/Shopify/sarama"
 
+// TheProducerFactory creates the producers of the gateway. A transactional id
+// may only be used by one producer, so transactional configs get only the sync producer.
 func TheProducerFactory(brokers []string, config *sarama.Config) (sarama.SyncProducer, sarama.AsyncProducer, error) {
 
 	syncProducer, err := sarama.NewSyncProducer(brokers, config)
 	if err != nil {
 		return nil, nil, err
 	}
+	if config.Producer.Transaction.ID != "" {
+		return syncProducer, nil, nil
+	}
 
 	asyncProducer, err := sarama.NewAsyncProducer(brokers, config)
 	if err != nil {
@@ -20,3 +25,40 @@ func TheProducerFactory(brokers []string, config *sarama.Config) (sarama.SyncPro
 
 	return syncProducer, asyncProducer, nil
 }
+
+func TheConsumerFactory(brokers []string, config *sarama.Config) (sarama.Client, sarama.Consumer, error) {
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		if closeErr := client.Close(); closeErr != nil {
+			return nil, nil, closeErr
+		}
+		return nil, nil, err
+	}
+
+	return client, consumer, nil
+}
+
+// TheAdminFactory creates the cluster admin and the client it runs on,
+// closing the admin closes the client
+func TheAdminFactory(brokers []string, config *sarama.Config) (sarama.Client, sarama.ClusterAdmin, error) {
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		if closeErr := client.Close(); closeErr != nil {
+			return nil, nil, closeErr
+		}
+		return nil, nil, err
+	}
+
+	return client, admin, nil
+}
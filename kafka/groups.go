@@ -0,0 +1,279 @@
+package kafka
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/Shopify/sarama"
+)
+
+var ErrGroupNotFound = errors.New("consumer group not found")
+
+// GroupSummary is a consumer group as listed by the cluster
+type GroupSummary struct {
+	Group        string
+	ProtocolType string
+}
+
+// GroupMember is a member of a consumer group and the partitions assigned to it
+type GroupMember struct {
+	MemberID   string
+	InstanceID string
+	ClientID   string
+	ClientHost string
+	Assignment []TopicPartition
+}
+
+type GroupDescription struct {
+	Group        string
+	State        string
+	ProtocolType string
+	// Protocol is the assignor of consumer groups, such as range
+	Protocol string
+	Members  []GroupMember
+}
+
+// PartitionLag is how far a consumer group is behind on a partition: the
+// records between its committed offset and the high-water mark
+type PartitionLag struct {
+	Topic         string
+	Partition     int32
+	Committed     int64
+	HighWaterMark int64
+	Lag           int64
+	// MemberID is the member the partition is assigned to, if any
+	MemberID string
+}
+
+// GroupLag is the lag of a consumer group on the partitions it committed
+type GroupLag struct {
+	Group      string
+	State      string
+	Lag        int64
+	Partitions []PartitionLag
+}
+
+// TopicLag is the lag of a consumer group on one topic
+type TopicLag struct {
+	Group string
+	Topic string
+	Lag   int64
+}
+
+// LagSummary ranks consumer groups, and the topics they read, by lag
+type LagSummary struct {
+	Groups []GroupLag
+	Topics []TopicLag
+}
+
+// ListGroups returns the consumer groups of the cluster sorted by name
+func (a *Admin) ListGroups() ([]GroupSummary, error) {
+	listed, err := a.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]GroupSummary, 0, len(listed))
+	for group, protocolType := range listed {
+		groups = append(groups, GroupSummary{Group: group, ProtocolType: protocolType})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Group < groups[j].Group })
+	return groups, nil
+}
+
+// DescribeGroup returns the state and members of the consumer group,
+// ErrGroupNotFound when there is no such group
+func (a *Admin) DescribeGroup(group string) (*GroupDescription, error) {
+	descriptions, err := a.describeGroups([]string{group})
+	if err != nil {
+		return nil, err
+	}
+	return descriptions[0], nil
+}
+
+// GroupLag returns the lag of the consumer group on every partition it
+// committed an offset for. Partitions never committed have no lag yet.
+func (a *Admin) GroupLag(group string) (*GroupLag, error) {
+	description, err := a.DescribeGroup(group)
+	if err != nil {
+		return nil, err
+	}
+	return a.groupLag(description, make(map[TopicPartition]int64))
+}
+
+// LagSummary returns the lag of every consumer group, the groups and topics
+// furthest behind first
+func (a *Admin) LagSummary() (*LagSummary, error) {
+	listed, err := a.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(listed))
+	for group := range listed {
+		names = append(names, group)
+	}
+	summary := &LagSummary{}
+	if len(names) == 0 {
+		return summary, nil
+	}
+	descriptions, err := a.describeGroups(names)
+	if err != nil {
+		return nil, err
+	}
+
+	// groups often read the same topics, ask for each high-water mark once
+	highWaterMarks := make(map[TopicPartition]int64)
+	for _, description := range descriptions {
+		lag, err := a.groupLag(description, highWaterMarks)
+		if err != nil {
+			return nil, err
+		}
+		summary.Groups = append(summary.Groups, *lag)
+
+		topics := make(map[string]int64)
+		for _, partition := range lag.Partitions {
+			topics[partition.Topic] += partition.Lag
+		}
+		for topic, total := range topics {
+			summary.Topics = append(summary.Topics, TopicLag{Group: lag.Group, Topic: topic, Lag: total})
+		}
+	}
+
+	sort.Slice(summary.Groups, func(i, j int) bool {
+		if summary.Groups[i].Lag != summary.Groups[j].Lag {
+			return summary.Groups[i].Lag > summary.Groups[j].Lag
+		}
+		return summary.Groups[i].Group < summary.Groups[j].Group
+	})
+	sort.Slice(summary.Topics, func(i, j int) bool {
+		x, y := summary.Topics[i], summary.Topics[j]
+		if x.Lag != y.Lag {
+			return x.Lag > y.Lag
+		}
+		if x.Group != y.Group {
+			return x.Group < y.Group
+		}
+		return x.Topic < y.Topic
+	})
+	return summary, nil
+}
+
+func (a *Admin) describeGroups(groups []string) ([]*GroupDescription, error) {
+	described, err := a.admin.DescribeConsumerGroups(groups)
+	if err != nil {
+		return nil, err
+	}
+	descriptions := make([]*GroupDescription, 0, len(described))
+	for _, group := range described {
+		if !errors.Is(group.Err, sarama.ErrNoError) {
+			return nil, group.Err
+		}
+		// the coordinator describes groups it doesn't know as dead
+		if group.State == "Dead" {
+			return nil, ErrGroupNotFound
+		}
+		descriptions = append(descriptions, groupDescription(group))
+	}
+	if len(descriptions) == 0 {
+		return nil, ErrGroupNotFound
+	}
+	return descriptions, nil
+}
+
+func groupDescription(group *sarama.GroupDescription) *GroupDescription {
+	description := &GroupDescription{
+		Group:        group.GroupId,
+		State:        group.State,
+		ProtocolType: group.ProtocolType,
+		Protocol:     group.Protocol,
+	}
+	for _, member := range group.Members {
+		m := GroupMember{MemberID: member.MemberId, ClientID: member.ClientId, ClientHost: member.ClientHost}
+		if member.GroupInstanceId != nil {
+			m.InstanceID = *member.GroupInstanceId
+		}
+		// only members of consumer groups have assignments sarama can read
+		if assignment, err := member.GetMemberAssignment(); err == nil && assignment != nil {
+			for topic, partitions := range assignment.Topics {
+				for _, partition := range partitions {
+					m.Assignment = append(m.Assignment, TopicPartition{Topic: topic, Partition: partition})
+				}
+			}
+			sortTopicPartitions(m.Assignment)
+		}
+		description.Members = append(description.Members, m)
+	}
+	sort.Slice(description.Members, func(i, j int) bool {
+		return description.Members[i].MemberID < description.Members[j].MemberID
+	})
+	return description
+}
+
+// groupLag compares the committed offsets of the group with the high-water
+// marks, which are cached in highWaterMarks
+func (a *Admin) groupLag(description *GroupDescription, highWaterMarks map[TopicPartition]int64) (*GroupLag, error) {
+	offsets, err := a.admin.ListConsumerGroupOffsets(description.Group, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !errors.Is(offsets.Err, sarama.ErrNoError) {
+		return nil, offsets.Err
+	}
+
+	owners := make(map[TopicPartition]string)
+	for _, member := range description.Members {
+		for _, tp := range member.Assignment {
+			owners[tp] = member.MemberID
+		}
+	}
+
+	lag := &GroupLag{Group: description.Group, State: description.State}
+	for topic, partitions := range offsets.Blocks {
+		for partition, block := range partitions {
+			if !errors.Is(block.Err, sarama.ErrNoError) {
+				return nil, block.Err
+			}
+			if block.Offset < 0 {
+				continue
+			}
+			tp := TopicPartition{Topic: topic, Partition: partition}
+			highWaterMark, ok := highWaterMarks[tp]
+			if !ok {
+				highWaterMark, err = a.client.GetOffset(topic, partition, sarama.OffsetNewest)
+				if err != nil {
+					return nil, err
+				}
+				highWaterMarks[tp] = highWaterMark
+			}
+			partitionLag := PartitionLag{
+				Topic:         topic,
+				Partition:     partition,
+				Committed:     block.Offset,
+				HighWaterMark: highWaterMark,
+				MemberID:      owners[tp],
+			}
+			// a committed offset may run ahead of a truncated partition
+			if highWaterMark > block.Offset {
+				partitionLag.Lag = highWaterMark - block.Offset
+			}
+			lag.Lag += partitionLag.Lag
+			lag.Partitions = append(lag.Partitions, partitionLag)
+		}
+	}
+	sort.Slice(lag.Partitions, func(i, j int) bool {
+		x, y := lag.Partitions[i], lag.Partitions[j]
+		if x.Topic != y.Topic {
+			return x.Topic < y.Topic
+		}
+		return x.Partition < y.Partition
+	})
+	return lag, nil
+}
+
+func sortTopicPartitions(partitions []TopicPartition) {
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+}
@@ -0,0 +1,169 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockOffsetClient answers partitions and their offsets from memory, every
+// partition has a high-water mark and starts at 0 unless logStartOffsets says otherwise
+type mockOffsetClient struct {
+	sarama.Client
+	highWaterMarks  map[TopicPartition]int64
+	logStartOffsets map[TopicPartition]int64
+	// timeOffsets is the offset of the first record since any time, -1 if none
+	timeOffsets map[TopicPartition]int64
+}
+
+func (c mockOffsetClient) Partitions(topic string) ([]int32, error) {
+	var partitions []int32
+	for tp := range c.highWaterMarks {
+		if tp.Topic == topic {
+			partitions = append(partitions, tp.Partition)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	return partitions, nil
+}
+
+func (c mockOffsetClient) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	tp := TopicPartition{Topic: topic, Partition: partition}
+	switch time {
+	case sarama.OffsetNewest:
+		return c.highWaterMarks[tp], nil
+	case sarama.OffsetOldest:
+		return c.logStartOffsets[tp], nil
+	default:
+		if offset, ok := c.timeOffsets[tp]; ok {
+			return offset, nil
+		}
+		return -1, nil
+	}
+}
+
+func (mockOffsetClient) Close() error {
+	return nil
+}
+
+// mockGroupAdmin serves consumer groups from memory
+type mockGroupAdmin struct {
+	sarama.ClusterAdmin
+	groups  map[string]*sarama.GroupDescription
+	offsets map[string]map[string]map[int32]int64
+}
+
+func (m *mockGroupAdmin) ListConsumerGroups() (map[string]string, error) {
+	groups := make(map[string]string, len(m.groups))
+	for name, group := range m.groups {
+		groups[name] = group.ProtocolType
+	}
+	return groups, nil
+}
+
+func (m *mockGroupAdmin) DescribeConsumerGroups(groups []string) ([]*sarama.GroupDescription, error) {
+	var descriptions []*sarama.GroupDescription
+	for _, name := range groups {
+		group, ok := m.groups[name]
+		if !ok {
+			group = &sarama.GroupDescription{GroupId: name, State: "Dead"}
+		}
+		descriptions = append(descriptions, group)
+	}
+	return descriptions, nil
+}
+
+func (m *mockGroupAdmin) ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	response := &sarama.OffsetFetchResponse{}
+	for topic, partitions := range m.offsets[group] {
+		for partition, offset := range partitions {
+			response.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: offset})
+		}
+	}
+	return response, nil
+}
+
+// memberAssignment encodes the assignment of a consumer group member to one topic
+func memberAssignment(topic string, partitions ...int32) []byte {
+	data := binary.BigEndian.AppendUint16(nil, 0)
+	data = binary.BigEndian.AppendUint32(data, 1)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(topic)))
+	data = append(data, topic...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(partitions)))
+	for _, partition := range partitions {
+		data = binary.BigEndian.AppendUint32(data, uint32(partition))
+	}
+	// no user data
+	return binary.BigEndian.AppendUint32(data, 0xffffffff)
+}
+
+func newGroupAdmin(t *testing.T) (*Admin, *mockGroupAdmin) {
+	clusterAdmin := &mockGroupAdmin{
+		groups: map[string]*sarama.GroupDescription{
+			"billing": {GroupId: "billing", State: "Stable", ProtocolType: "consumer", Protocol: "range", Members: map[string]*sarama.GroupMemberDescription{
+				"billing-1": {MemberId: "billing-1", ClientId: "billing", ClientHost: "/10.0.0.1", MemberAssignment: memberAssignment("orders", 1, 0)},
+			}},
+			"audit": {GroupId: "audit", State: "Empty", ProtocolType: "consumer"},
+		},
+		offsets: map[string]map[string]map[int32]int64{
+			"billing": {"orders": {0: 90, 1: 100}},
+			"audit":   {"orders": {0: 10, 1: -1}, "payments": {0: 5}},
+		},
+	}
+	client := mockOffsetClient{
+		highWaterMarks: map[TopicPartition]int64{
+			{Topic: "orders", Partition: 0}:   100,
+			{Topic: "orders", Partition: 1}:   100,
+			{Topic: "payments", Partition: 0}: 25,
+		},
+		logStartOffsets: map[TopicPartition]int64{{Topic: "orders", Partition: 0}: 5},
+		timeOffsets:     map[TopicPartition]int64{{Topic: "orders", Partition: 0}: 40},
+	}
+	admin, err := NewAdmin(nil, nil, func(brokers []string, conf *sarama.Config) (sarama.Client, sarama.ClusterAdmin, error) {
+		return client, clusterAdmin, nil
+	}, 1, 1)
+	assert.NoError(t, err)
+	return admin, clusterAdmin
+}
+
+func TestAdminGroups(t *testing.T) {
+	admin, _ := newGroupAdmin(t)
+
+	groups, err := admin.ListGroups()
+	assert.NoError(t, err)
+	assert.Equal(t, []GroupSummary{{Group: "audit", ProtocolType: "consumer"}, {Group: "billing", ProtocolType: "consumer"}}, groups)
+
+	description, err := admin.DescribeGroup("billing")
+	assert.NoError(t, err)
+	assert.Equal(t, "Stable", description.State)
+	assert.Len(t, description.Members, 1)
+	assert.Equal(t, []TopicPartition{{Topic: "orders", Partition: 0}, {Topic: "orders", Partition: 1}}, description.Members[0].Assignment)
+
+	_, err = admin.DescribeGroup("unknown")
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+
+	lag, err := admin.GroupLag("billing")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), lag.Lag)
+	assert.Equal(t, PartitionLag{Topic: "orders", Partition: 0, Committed: 90, HighWaterMark: 100, Lag: 10, MemberID: "billing-1"}, lag.Partitions[0])
+}
+
+func TestAdminLagSummary(t *testing.T) {
+	admin, _ := newGroupAdmin(t)
+
+	summary, err := admin.LagSummary()
+	assert.NoError(t, err)
+	assert.Len(t, summary.Groups, 2)
+	assert.Equal(t, "audit", summary.Groups[0].Group)
+	assert.Equal(t, int64(110), summary.Groups[0].Lag)
+	assert.Len(t, summary.Groups[0].Partitions, 2, "partitions without a committed offset have no lag")
+	assert.Equal(t, []TopicLag{
+		{Group: "audit", Topic: "orders", Lag: 90},
+		{Group: "audit", Topic: "payments", Lag: 20},
+		{Group: "billing", Topic: "orders", Lag: 10},
+	}, summary.Topics)
+}
@@ -0,0 +1,228 @@
+package kafka
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// Partitioner names accepted in configuration
+const (
+	// PartitionerHash is sarama's FNV-1a hash of the key, the default
+	PartitionerHash = "hash"
+	// PartitionerMurmur2 hashes keys like the Java client so keys land on the same partitions
+	PartitionerMurmur2    = "murmur2"
+	PartitionerRoundRobin = "round-robin"
+	PartitionerRandom     = "random"
+	// PartitionerSticky hashes keys with murmur2 and keeps keyless records on
+	// one partition for a while, like the Java client's default partitioner
+	PartitionerSticky = "sticky"
+)
+
+// stickyBatchSize is the number of keyless records sent to a partition before the sticky partitioner moves on
+const stickyBatchSize = 100
+
+// NewTopicPartitioner builds the partitioner of the producer. Topics listed in
+// perTopic use the partitioner named there, the other ones defaultName.
+// Messages with a partition of their own bypass the partitioner, see Message.
+func NewTopicPartitioner(defaultName string, perTopic map[string]string) (sarama.PartitionerConstructor, error) {
+	if defaultName == "" {
+		defaultName = PartitionerHash
+	}
+	if _, err := partitionerConstructor(defaultName); err != nil {
+		return nil, err
+	}
+	for topic, name := range perTopic {
+		if _, err := partitionerConstructor(name); err != nil {
+			return nil, fmt.Errorf("topic %s: %w", topic, err)
+		}
+	}
+
+	return func(topic string) sarama.Partitioner {
+		name, ok := perTopic[topic]
+		if !ok {
+			name = defaultName
+		}
+		constructor, _ := partitionerConstructor(name)
+		return &explicitPartitioner{Partitioner: constructor(topic)}
+	}, nil
+}
+
+// ParseTopicPartitioners reads comma separated topic:partitioner pairs
+func ParseTopicPartitioners(value string) (map[string]string, error) {
+	partitioners := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		topic, name, ok := strings.Cut(pair, ":")
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("invalid topic partitioner %q, expected topic:partitioner", pair)
+		}
+		partitioners[topic] = name
+	}
+	return partitioners, nil
+}
+
+func partitionerConstructor(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case PartitionerHash:
+		return sarama.NewHashPartitioner, nil
+	case PartitionerMurmur2:
+		return newMurmur2Partitioner, nil
+	case PartitionerRoundRobin:
+		return sarama.NewRoundRobinPartitioner, nil
+	case PartitionerRandom:
+		return sarama.NewRandomPartitioner, nil
+	case PartitionerSticky:
+		return newStickyPartitioner, nil
+	default:
+		return nil, fmt.Errorf("unknown partitioner %q", name)
+	}
+}
+
+// explicitPartitioner sends messages that already carry a partition there
+// and lets the wrapped partitioner choose for the others
+type explicitPartitioner struct {
+	sarama.Partitioner
+}
+
+func (p *explicitPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Partition >= 0 {
+		// sarama rejects partitions out of range with ErrInvalidPartition
+		return message.Partition, nil
+	}
+	return p.Partitioner.Partition(message, numPartitions)
+}
+
+// MessageRequiresConsistency makes sarama count every partition, not only
+// the writable ones, whenever the partition number must be taken literally
+func (p *explicitPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	if message.Partition >= 0 {
+		return true
+	}
+	if dynamic, ok := p.Partitioner.(sarama.DynamicConsistencyPartitioner); ok {
+		return dynamic.MessageRequiresConsistency(message)
+	}
+	return p.Partitioner.RequiresConsistency()
+}
+
+// murmur2Partitioner picks partitions like the Java client's default partitioner
+// does for keyed records, keyless records go to a random partition
+type murmur2Partitioner struct {
+	random sarama.Partitioner
+}
+
+func newMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{random: sarama.NewRandomPartitioner(topic)}
+}
+
+func (p *murmur2Partitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return p.random.Partition(message, numPartitions)
+	}
+	key, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return murmur2Partition(key, numPartitions), nil
+}
+
+func (p *murmur2Partitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (p *murmur2Partitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	return message.Key != nil
+}
+
+// stickyPartitioner hashes keys with murmur2 and sends keyless records to the
+// same partition until stickyBatchSize of them went there
+type stickyPartitioner struct {
+	mutex     sync.Mutex
+	partition int32
+	sent      int
+}
+
+func newStickyPartitioner(topic string) sarama.Partitioner {
+	return &stickyPartitioner{partition: -1}
+}
+
+func (p *stickyPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key != nil {
+		key, err := message.Key.Encode()
+		if err != nil {
+			return -1, err
+		}
+		return murmur2Partition(key, numPartitions), nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.partition < 0 || p.partition >= numPartitions || p.sent >= stickyBatchSize {
+		next := rand.Int31n(numPartitions)
+		// move to another partition when there is one
+		if numPartitions > 1 && next == p.partition {
+			next = (next + 1) % numPartitions
+		}
+		p.partition, p.sent = next, 0
+	}
+	p.sent++
+	return p.partition, nil
+}
+
+func (p *stickyPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (p *stickyPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	return message.Key != nil
+}
+
+func murmur2Partition(key []byte, numPartitions int32) int32 {
+	// toPositive in the Java client
+	return (murmur2(key) & 0x7fffffff) % numPartitions
+}
+
+// murmur2 is the Java client's Utils.murmur2
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
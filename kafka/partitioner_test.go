@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMurmur2(t *testing.T) {
+	// the cases of the Java client's UtilsTest.testMurmur2
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, expected := range cases {
+		assert.Equal(t, expected, murmur2([]byte(key)), key)
+	}
+}
+
+func TestTopicPartitioner(t *testing.T) {
+	constructor, err := NewTopicPartitioner(PartitionerHash, map[string]string{"orders": PartitionerMurmur2, "events": PartitionerSticky})
+	assert.NoError(t, err)
+
+	keyed := &sarama.ProducerMessage{Topic: "orders", Key: sarama.StringEncoder("foobar"), Partition: -1}
+	partition, err := constructor("orders").Partition(keyed, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, (int32(-790332482)&0x7fffffff)%10, partition, "keys should land where the Java client puts them")
+
+	explicit := &sarama.ProducerMessage{Topic: "orders", Key: sarama.StringEncoder("foobar"), Partition: 7}
+	partition, err = constructor("orders").Partition(explicit, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(7), partition, "an explicit partition should win over the key")
+	assert.True(t, constructor("orders").(sarama.DynamicConsistencyPartitioner).MessageRequiresConsistency(explicit))
+
+	sticky := constructor("events")
+	first, err := sticky.Partition(&sarama.ProducerMessage{Topic: "events", Partition: -1}, 10)
+	assert.NoError(t, err)
+	for i := 1; i < stickyBatchSize; i++ {
+		partition, _ := sticky.Partition(&sarama.ProducerMessage{Topic: "events", Partition: -1}, 10)
+		assert.Equal(t, first, partition, "keyless records should stick to a partition")
+	}
+	partition, _ = sticky.Partition(&sarama.ProducerMessage{Topic: "events", Partition: -1}, 10)
+	assert.NotEqual(t, first, partition, "the sticky partitioner should move on after a batch")
+
+	_, err = NewTopicPartitioner(PartitionerHash, map[string]string{"orders": "crc32"})
+	assert.Error(t, err)
+
+	partitioners, err := ParseTopicPartitioners("orders:murmur2, events:sticky")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"orders": PartitionerMurmur2, "events": PartitionerSticky}, partitioners)
+	_, err = ParseTopicPartitioners("orders")
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
@@ -16,34 +17,120 @@ var initErr error
 
 type ProducerFactory func(brokers []string, conf *sarama.Config) (sarama.SyncProducer, sarama.AsyncProducer, error)
 
+// Message is a record to produce, a nil Key produces a record without a key
+// and a nil Partition leaves the choice to the partitioner of the topic
+type Message struct {
+	// ID identifies the message in delivery reports, messages without one aren't tracked
+	ID        string
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Partition *int32
+}
+
+// RecordMetadata tells where a produced record was written
+type RecordMetadata struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+}
+
+func (m Message) producerMessage() *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{
+		Topic:     m.Topic,
+		Value:     sarama.ByteEncoder(m.Value),
+		Timestamp: time.Now().UTC(),
+		Offset:    sarama.OffsetNewest,
+		// honoured by the partitioners of NewTopicPartitioner
+		Partition: -1,
+	}
+	if m.Partition != nil {
+		msg.Partition = *m.Partition
+	}
+	if m.Key != nil {
+		msg.Key = sarama.ByteEncoder(m.Key)
+	}
+	// sorted so the same headers always produce the same record
+	keys := make([]string, 0, len(m.Headers))
+	for key := range m.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(m.Headers[key])})
+	}
+	return msg
+}
+
 type Producer struct {
 	syncProducer  sarama.SyncProducer
 	asyncProducer sarama.AsyncProducer
 	mutex         *sync.Mutex
+	reports       *DeliveryReports
+
+	// retryMutex guards the retry policy and closed
+	retryMutex  sync.Mutex
+	retryPolicy RetryPolicy
+	deadLetters DeadLetterHandler
+	closed      bool
+	closing     chan struct{}
+	// retries counts the async messages waiting for their next attempt
+	retries sync.WaitGroup
 }
 
-func NewProducer(brokers []string, conf *sarama.Config, factory ProducerFactory) (*Producer, error) {
-	once.Do(func() {
-		var config *sarama.Config
-		if conf == nil {
-			config = sarama.NewConfig()
-		} else {
-			config = conf
-		}
-		if config.Producer.RequiredAcks == sarama.NoResponse {
-			config.Producer.RequiredAcks = sarama.WaitForAll
-		}
-		if config.Producer.Retry.Max < 10 {
-			config.Producer.Retry.Max = 10
-		}
+// producerConfig fills in the settings every producer of the gateway relies on
+func producerConfig(conf *sarama.Config) *sarama.Config {
+	var config *sarama.Config
+	if conf == nil {
+		config = sarama.NewConfig()
+	} else {
+		config = conf
+	}
+	if config.Producer.RequiredAcks == sarama.NoResponse {
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	}
+	if config.Producer.Retry.Max < 10 {
+		config.Producer.Retry.Max = 10
+	}
 
-		config.Producer.Return.Successes = true
-		config.Producer.Compression = sarama.CompressionSnappy
+	config.Producer.Return.Successes = true
+	config.Producer.Compression = sarama.CompressionSnappy
 
-		// set group id if not set
-		if config.ClientID == "" {
-			config.ClientID = "kafka-go"
-		}
+	// set group id if not set
+	if config.ClientID == "" {
+		config.ClientID = "kafka-go"
+	}
+	return config
+}
+
+// EnableIdempotence makes the producers built from conf idempotent, the
+// brokers then drop the duplicates that retries would otherwise write
+func EnableIdempotence(conf *sarama.Config) {
+	conf.Producer.Idempotent = true
+	conf.Producer.RequiredAcks = sarama.WaitForAll
+	conf.Net.MaxOpenRequests = 1
+	if !conf.Version.IsAtLeast(sarama.V0_11_0_0) {
+		conf.Version = sarama.V0_11_0_0
+	}
+}
+
+// ProducerOption changes a producer before it starts
+type ProducerOption func(p *Producer)
+
+// WithDeliveryReports keeps the delivery reports of the producer in reports
+func WithDeliveryReports(reports *DeliveryReports) ProducerOption {
+	return func(p *Producer) {
+		p.reports = reports
+	}
+}
+
+// NewProducer returns the producer of the gateway, creating it on the first
+// call. The options only apply to that first call.
+func NewProducer(brokers []string, conf *sarama.Config, factory ProducerFactory, opts ...ProducerOption) (*Producer, error) {
+	once.Do(func() {
+		config := producerConfig(conf)
 
 		syncProducer, asyncProducer, err := factory(brokers, config)
 		if err != nil {
@@ -55,7 +142,14 @@ func NewProducer(brokers []string, conf *sarama.Config, factory ProducerFactory)
 			syncProducer:  syncProducer,
 			asyncProducer: asyncProducer,
 			mutex:         &sync.Mutex{},
+			reports:       NewDeliveryReports(DefaultDeliveryReportTTL, DefaultDeliveryReportLimit),
+			retryPolicy:   DefaultRetryPolicy(),
+			closing:       make(chan struct{}),
+		}
+		for _, opt := range opts {
+			opt(instance)
 		}
+		go instance.dispatch()
 
 		log.Println("🚀 successfully connected to Kafka producer")
 	})
@@ -70,47 +164,126 @@ func NewProducer(brokers []string, conf *sarama.Config, factory ProducerFactory)
 }
 
 func (p *Producer) SendMessageSync(topic string, key string, value string) (partition int32, offset int64, err error) {
+	message := Message{Topic: topic, Value: []byte(value)}
+	if key != "" {
+		message.Key = []byte(key)
+	}
+	metadata, err := p.Send(message)
+	return metadata.Partition, metadata.Offset, err
+}
+
+// Send produces the message and waits for the broker to acknowledge it
+func (p *Producer) Send(message Message) (RecordMetadata, error) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	msg := &sarama.ProducerMessage{
-		Topic:     topic,
-		Value:     sarama.StringEncoder(value),
-		Timestamp: time.Now().UTC(),
-		Offset:    sarama.OffsetNewest,
+	msg := message.producerMessage()
+	partition, offset, err := p.syncProducer.SendMessage(msg)
+	if err != nil {
+		p.reports.failed(message, err, 1)
+		return RecordMetadata{}, err
 	}
-	if key != "" {
-		msg.Key = sarama.StringEncoder(key)
+	metadata := RecordMetadata{
+		Topic:     msg.Topic,
+		Partition: partition,
+		Offset:    offset,
+		Timestamp: msg.Timestamp,
 	}
-	partition, offset, err = p.syncProducer.SendMessage(msg)
-	return
+	p.reports.delivered(message, metadata, 1)
+	return metadata, nil
 }
 
-func (p *Producer) SendMessageAsync(topic string, key string, value string) {
-	// autos-elect partition and offset for message
-	msg := &sarama.ProducerMessage{
-		Topic:     topic,
-		Value:     sarama.StringEncoder(value),
-		Timestamp: time.Now().UTC(),
-		Offset:    sarama.OffsetNewest,
+// BatchResult is the outcome of one message of a batch, Err is set when it was not written
+type BatchResult struct {
+	Metadata RecordMetadata
+	Err      error
+}
+
+// SendBatch produces the messages in one go and reports on each of them in
+// the order they were given. A failing message doesn't stop the others.
+func (p *Producer) SendBatch(messages []Message) []BatchResult {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	msgs := make([]*sarama.ProducerMessage, len(messages))
+	for i, message := range messages {
+		msgs[i] = message.producerMessage()
+	}
+
+	err := p.syncProducer.SendMessages(msgs)
+	failed := make(map[*sarama.ProducerMessage]error)
+	var producerErrors sarama.ProducerErrors
+	if errors.As(err, &producerErrors) {
+		for _, producerError := range producerErrors {
+			failed[producerError.Msg] = producerError.Err
+		}
+		err = nil
 	}
+
+	results := make([]BatchResult, len(msgs))
+	for i, msg := range msgs {
+		if msgErr, ok := failed[msg]; ok {
+			results[i].Err = msgErr
+		} else if err != nil && msg.Offset < 0 {
+			// any other error leaves us with the messages that got an offset as the only ones written
+			results[i].Err = err
+		}
+		if results[i].Err != nil {
+			p.reports.failed(messages[i], results[i].Err, 1)
+			continue
+		}
+		results[i].Metadata = RecordMetadata{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Timestamp: msg.Timestamp,
+		}
+		p.reports.delivered(messages[i], results[i].Metadata, 1)
+	}
+	return results
+}
+
+func (p *Producer) SendMessageAsync(topic string, key string, value string) {
+	message := Message{Topic: topic, Value: []byte(value)}
 	if key != "" {
-		msg.Key = sarama.StringEncoder(key)
+		message.Key = []byte(key)
 	}
+	p.SendAsync(message)
+}
+
+// SendAsync hands the message to the producer without waiting. Failures are
+// retried as the retry policy says and then handed to the dead letter handler.
+func (p *Producer) SendAsync(message Message) {
+	// autos-elect partition and offset for message
+	msg := message.producerMessage()
+	msg.Metadata = &delivery{message: message, first: msg.Timestamp}
+	p.reports.pending(message, 0, nil)
 	p.asyncProducer.Input() <- msg
+}
 
-	go func() {
-		select {
-		case <-p.asyncProducer.Successes():
-			// Message successfully delivered
-		case err := <-p.asyncProducer.Errors():
-			log.Printf("failed to produce message: %v\n", err.Err)
-		}
-	}()
+// Accepted reports the message pending until SendAsync takes it, for
+// messages that wait in a queue before being handed to the producer
+func (p *Producer) Accepted(message Message) {
+	p.reports.pending(message, 0, nil)
+}
 
+// DeliveryReport returns the report of the message with the id
+func (p *Producer) DeliveryReport(id string) (DeliveryReport, bool) {
+	return p.reports.Get(id)
 }
 
 func (p *Producer) Close() error {
+	p.retryMutex.Lock()
+	if !p.closed {
+		p.closed = true
+		if p.closing != nil {
+			close(p.closing)
+		}
+	}
+	p.retryMutex.Unlock()
+	// retries waiting for their turn give up, the ones under way get their results
+	p.retries.Wait()
+
 	if err := p.syncProducer.Close(); err != nil {
 		log.Fatalln("failed to shut down sync producer cleanly", err)
 	}
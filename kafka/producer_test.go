@@ -2,6 +2,7 @@ package kafka
 
 import (
 	"errors"
+	"fmt"
 	"github.com/Shopify/sarama"
 	"github.com/Shopify/sarama/mocks"
 	"reflect"
@@ -95,3 +96,72 @@ func TestSendMessagesASync(t *testing.T) {
 
 	}
 }
+
+func TestSend(t *testing.T) {
+	// Given
+	mockSyncProducer := mocks.NewSyncProducer(t, nil)
+	mockSyncProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Key != nil {
+			return errors.New("expected a record without key")
+		}
+		if len(msg.Headers) != 2 || string(msg.Headers[0].Key) != "event-type" || string(msg.Headers[1].Value) != "acme" {
+			return fmt.Errorf("unexpected headers %v", msg.Headers)
+		}
+		return nil
+	})
+	mockSyncProducer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+
+	producer := &Producer{
+		syncProducer: mockSyncProducer,
+		mutex:        &sync.Mutex{},
+	}
+
+	// When
+	metadata, err := producer.Send(Message{
+		Topic:   "test-topic",
+		Value:   []byte("test-value"),
+		Headers: map[string]string{"tenant": "acme", "event-type": "created"},
+	})
+
+	// Then
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if metadata.Topic != "test-topic" || metadata.Offset != 1 || metadata.Timestamp.IsZero() {
+		t.Errorf("Unexpected record metadata %+v", metadata)
+	}
+
+	_, err = producer.Send(Message{Topic: "test-topic", Value: []byte("test-value")})
+	if !errors.Is(err, sarama.ErrNotEnoughReplicas) {
+		t.Errorf("Expected ErrNotEnoughReplicas, got %v", err)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	// Given
+	mockSyncProducer := mocks.NewSyncProducer(t, nil)
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	mockSyncProducer.ExpectSendMessageAndFail(sarama.ErrMessageSizeTooLarge)
+
+	producer := &Producer{
+		syncProducer: mockSyncProducer,
+		mutex:        &sync.Mutex{},
+	}
+
+	// When
+	results := producer.SendBatch([]Message{
+		{Topic: "test-topic", Value: []byte("first")},
+		{Topic: "other-topic", Key: []byte("key"), Value: []byte("second")},
+	})
+
+	// Then
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	if results[0].Err != nil || results[0].Metadata.Topic != "test-topic" || results[0].Metadata.Offset != 1 {
+		t.Errorf("Unexpected result for the first message %+v", results[0])
+	}
+	if !errors.Is(results[1].Err, sarama.ErrMessageSizeTooLarge) {
+		t.Errorf("Expected ErrMessageSizeTooLarge for the second message, got %v", results[1].Err)
+	}
+}
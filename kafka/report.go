@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"sync"
+	"time"
+)
+
+// Delivery report defaults
+const (
+	DefaultDeliveryReportTTL   = time.Hour
+	DefaultDeliveryReportLimit = 100000
+)
+
+// Delivery states of a message
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// DeliveryReport tells what became of a message with an ID
+type DeliveryReport struct {
+	ID     string
+	Topic  string
+	Status string
+	// Partition, Offset and Timestamp are set once the message is delivered
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+	Attempts  int
+	// Error is why the last attempt failed
+	Error     string
+	UpdatedAt time.Time
+}
+
+type reportEntry struct {
+	id      string
+	created time.Time
+}
+
+// DeliveryReports keeps the reports of recent messages in memory. Reports
+// are dropped after the ttl, or earlier once there are more than limit.
+// A nil *DeliveryReports keeps nothing.
+type DeliveryReports struct {
+	mutex   sync.Mutex
+	reports map[string]*DeliveryReport
+	// order lists the reports oldest first, for expiry. order[:head] were
+	// dropped already, they are removed once they are half of it.
+	order []reportEntry
+	head  int
+	ttl   time.Duration
+	limit int
+}
+
+func NewDeliveryReports(ttl time.Duration, limit int) *DeliveryReports {
+	if ttl <= 0 {
+		ttl = DefaultDeliveryReportTTL
+	}
+	if limit <= 0 {
+		limit = DefaultDeliveryReportLimit
+	}
+	return &DeliveryReports{reports: make(map[string]*DeliveryReport), ttl: ttl, limit: limit}
+}
+
+// Get returns the report of the message
+func (r *DeliveryReports) Get(id string) (DeliveryReport, bool) {
+	if r == nil {
+		return DeliveryReport{}, false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expire(time.Now())
+
+	report, ok := r.reports[id]
+	if !ok {
+		return DeliveryReport{}, false
+	}
+	return *report, true
+}
+
+func (r *DeliveryReports) pending(message Message, attempts int, err error) {
+	r.update(message, func(report *DeliveryReport) {
+		report.Status, report.Attempts = DeliveryPending, attempts
+		if err != nil {
+			report.Error = err.Error()
+		}
+	})
+}
+
+func (r *DeliveryReports) delivered(message Message, metadata RecordMetadata, attempts int) {
+	r.update(message, func(report *DeliveryReport) {
+		report.Status, report.Attempts, report.Error = DeliveryDelivered, attempts, ""
+		report.Partition, report.Offset, report.Timestamp = metadata.Partition, metadata.Offset, metadata.Timestamp
+	})
+}
+
+func (r *DeliveryReports) failed(message Message, err error, attempts int) {
+	r.update(message, func(report *DeliveryReport) {
+		report.Status, report.Attempts, report.Error = DeliveryFailed, attempts, err.Error()
+	})
+}
+
+func (r *DeliveryReports) update(message Message, change func(report *DeliveryReport)) {
+	if r == nil || message.ID == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	report, ok := r.reports[message.ID]
+	if !ok {
+		report = &DeliveryReport{ID: message.ID, Topic: message.Topic}
+		r.reports[message.ID] = report
+		r.order = append(r.order, reportEntry{id: message.ID, created: now})
+	}
+	change(report)
+	report.UpdatedAt = now
+	r.expire(now)
+}
+
+// expire drops the reports past the ttl or the limit, r.mutex must be held
+func (r *DeliveryReports) expire(now time.Time) {
+	for r.head < len(r.order) {
+		entry := r.order[r.head]
+		if now.Sub(entry.created) < r.ttl && len(r.order)-r.head <= r.limit {
+			break
+		}
+		delete(r.reports, entry.id)
+		r.order[r.head] = reportEntry{}
+		r.head++
+	}
+	// compacting only now and then keeps expiry O(1) on average
+	if r.head > 0 && r.head >= len(r.order)/2 {
+		n := copy(r.order, r.order[r.head:])
+		r.order = r.order[:n]
+		r.head = 0
+	}
+}
@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryReports(t *testing.T) {
+	mockSyncProducer := mocks.NewSyncProducer(t, nil)
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	mockSyncProducer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+	producer := &Producer{
+		syncProducer: mockSyncProducer,
+		mutex:        &sync.Mutex{},
+		reports:      NewDeliveryReports(time.Minute, 2),
+	}
+
+	_, err := producer.Send(Message{ID: "a", Topic: "orders", Value: []byte("created")})
+	assert.NoError(t, err)
+	report, ok := producer.DeliveryReport("a")
+	assert.True(t, ok)
+	assert.Equal(t, DeliveryDelivered, report.Status)
+	assert.Equal(t, "orders", report.Topic)
+	assert.Equal(t, int64(1), report.Offset)
+
+	_, err = producer.Send(Message{ID: "b", Topic: "orders", Value: []byte("paid")})
+	assert.Error(t, err)
+	report, _ = producer.DeliveryReport("b")
+	assert.Equal(t, DeliveryFailed, report.Status)
+	assert.Equal(t, sarama.ErrNotEnoughReplicas.Error(), report.Error)
+
+	// past the limit the oldest reports go
+	producer.reports.pending(Message{ID: "c", Topic: "orders"}, 0, nil)
+	_, ok = producer.DeliveryReport("a")
+	assert.False(t, ok)
+	report, ok = producer.DeliveryReport("c")
+	assert.True(t, ok)
+	assert.Equal(t, DeliveryPending, report.Status)
+
+	// and past the ttl all of them
+	expiring := NewDeliveryReports(time.Millisecond, 10)
+	expiring.pending(Message{ID: "d", Topic: "orders"}, 0, nil)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = expiring.Get("d")
+	assert.False(t, ok)
+
+	// messages waiting to be handed to SendAsync are pending already
+	producer.Accepted(Message{ID: "e", Topic: "orders"})
+	report, ok = producer.DeliveryReport("e")
+	assert.True(t, ok)
+	assert.Equal(t, DeliveryPending, report.Status)
+
+	// expired entries don't pile up in the expiry order
+	bounded := NewDeliveryReports(time.Minute, 3)
+	for i := 0; i < 100; i++ {
+		bounded.pending(Message{ID: fmt.Sprint(i), Topic: "orders"}, 0, nil)
+	}
+	assert.Len(t, bounded.reports, 3)
+	assert.Equal(t, 3, len(bounded.order)-bounded.head)
+	assert.LessOrEqual(t, len(bounded.order), 6)
+	_, ok = bounded.Get("99")
+	assert.True(t, ok)
+	_, ok = bounded.Get("96")
+	assert.False(t, ok)
+
+	// messages without an id aren't tracked
+	producer.reports.pending(Message{Topic: "orders"}, 0, nil)
+	_, ok = producer.DeliveryReport("")
+	assert.False(t, ok)
+}
@@ -0,0 +1,342 @@
+package kafka
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Offset reset modes, named after the options of kafka-consumer-groups --reset-offsets
+const (
+	ResetToEarliest       = "to-earliest"
+	ResetToLatest         = "to-latest"
+	ResetToDatetime       = "to-datetime"
+	ResetShiftBy          = "shift-by"
+	ResetToExplicitOffset = "to-explicit-offset"
+)
+
+// resetPreviewTTL is how long the token of a preview can execute it
+const resetPreviewTTL = 10 * time.Minute
+
+var (
+	ErrGroupActive        = errors.New("consumer group has active members, stop them first")
+	ErrResetNotPreviewed  = errors.New("offset reset does not match a preview or the preview expired, preview it first")
+	ErrResetStale         = errors.New("committed offsets changed since the preview, preview the reset again")
+	ErrInvalidOffsetReset = errors.New("invalid offset reset")
+)
+
+// OffsetReset says which partitions of a group to move and where. The mode
+// applies to every partition of Topics and to Partitions.
+type OffsetReset struct {
+	Mode       string
+	Topics     []string
+	Partitions []TopicPartition
+	// Datetime is the target of to-datetime, partitions move to the first record at or after it
+	Datetime time.Time
+	// Shift is added to the committed offsets by shift-by, negative to go back
+	Shift int64
+	// Offset is the target of to-explicit-offset
+	Offset int64
+}
+
+// PartitionReset is the move of one partition, Current is -1 when the group
+// has no offset committed there
+type PartitionReset struct {
+	Topic     string
+	Partition int32
+	Current   int64
+	Target    int64
+}
+
+// OffsetResetPlan is what a reset does to a group. Token is issued by a
+// preview, executing the reset takes it.
+type OffsetResetPlan struct {
+	Group         string
+	Mode          string
+	Token         string
+	ActiveMembers int
+	Partitions    []PartitionReset
+}
+
+// resetPreview is a preview waiting to be executed, fingerprint identifies
+// the reset it was made for
+type resetPreview struct {
+	fingerprint string
+	plan        OffsetResetPlan
+	expires     time.Time
+}
+
+// PreviewOffsetReset plans the reset, nothing is committed. The plan gets a
+// random token that executes exactly these targets within resetPreviewTTL.
+func (a *Admin) PreviewOffsetReset(group string, reset OffsetReset) (*OffsetResetPlan, error) {
+	plan, err := a.planOffsetReset(group, reset)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plan.Token = hex.EncodeToString(nonce)
+
+	a.previewMutex.Lock()
+	defer a.previewMutex.Unlock()
+	now := time.Now()
+	for token, preview := range a.previews {
+		if now.After(preview.expires) {
+			delete(a.previews, token)
+		}
+	}
+	a.previews[plan.Token] = &resetPreview{
+		fingerprint: resetFingerprint(group, reset, resetTopicPartitions(plan.Partitions)),
+		plan:        *plan,
+		expires:     now.Add(a.previewTTL),
+	}
+	return plan, nil
+}
+
+// planOffsetReset works out the targets of the reset. Targets out of the
+// range of a partition are moved to its earliest or latest offset.
+func (a *Admin) planOffsetReset(group string, reset OffsetReset) (*OffsetResetPlan, error) {
+	description, err := a.DescribeGroup(group)
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := a.resetPartitions(reset)
+	if err != nil {
+		return nil, err
+	}
+
+	request := make(map[string][]int32)
+	for _, tp := range partitions {
+		request[tp.Topic] = append(request[tp.Topic], tp.Partition)
+	}
+	committed, err := a.admin.ListConsumerGroupOffsets(group, request)
+	if err != nil {
+		return nil, err
+	}
+	if !errors.Is(committed.Err, sarama.ErrNoError) {
+		return nil, committed.Err
+	}
+
+	plan := &OffsetResetPlan{
+		Group:         group,
+		Mode:          reset.Mode,
+		ActiveMembers: len(description.Members),
+	}
+	for _, tp := range partitions {
+		current := int64(-1)
+		if block := committed.GetBlock(tp.Topic, tp.Partition); block != nil {
+			if !errors.Is(block.Err, sarama.ErrNoError) {
+				return nil, block.Err
+			}
+			current = block.Offset
+		}
+		target, err := a.resetTarget(tp, current, reset)
+		if err != nil {
+			return nil, err
+		}
+		plan.Partitions = append(plan.Partitions, PartitionReset{Topic: tp.Topic, Partition: tp.Partition, Current: current, Target: target})
+	}
+	return plan, nil
+}
+
+// ResetOffsets commits the targets of the reset previewed with the token,
+// which can only be used once. The reset is refused when the committed
+// offsets moved since the preview, and for groups with members, which would
+// overwrite the offsets with their own.
+func (a *Admin) ResetOffsets(group string, reset OffsetReset, token string) (*OffsetResetPlan, error) {
+	a.resetMutex.Lock()
+	defer a.resetMutex.Unlock()
+
+	a.previewMutex.Lock()
+	preview, ok := a.previews[token]
+	delete(a.previews, token)
+	a.previewMutex.Unlock()
+	if !ok || time.Now().After(preview.expires) || preview.plan.Group != group {
+		return nil, ErrResetNotPreviewed
+	}
+
+	current, err := a.planOffsetReset(group, reset)
+	if err != nil {
+		return nil, err
+	}
+	if preview.fingerprint != resetFingerprint(group, reset, resetTopicPartitions(current.Partitions)) {
+		return nil, ErrResetNotPreviewed
+	}
+	if current.ActiveMembers > 0 {
+		return nil, ErrGroupActive
+	}
+	plan := &preview.plan
+	for i, partition := range plan.Partitions {
+		if current.Partitions[i].Current != partition.Current {
+			return nil, ErrResetStale
+		}
+	}
+
+	offsets, err := a.offsetManagers(group, a.client)
+	if err != nil {
+		return nil, err
+	}
+	for _, partition := range plan.Partitions {
+		manager, err := offsets.ManagePartition(partition.Topic, partition.Partition)
+		if err != nil {
+			_ = offsets.Close()
+			return nil, err
+		}
+		// the offset manager only moves forward with MarkOffset and back with ResetOffset
+		if partition.Target < partition.Current {
+			manager.ResetOffset(partition.Target, "")
+		} else {
+			manager.MarkOffset(partition.Target, "")
+		}
+	}
+	offsets.Commit()
+	if err := offsets.Close(); err != nil {
+		return nil, err
+	}
+
+	// the offset manager doesn't tell whether its commit went through
+	if err := a.checkCommitted(plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// resetPartitions lists the partitions of the reset, sorted and without duplicates
+func (a *Admin) resetPartitions(reset OffsetReset) ([]TopicPartition, error) {
+	unique := make(map[TopicPartition]bool)
+	for _, topic := range reset.Topics {
+		partitions, err := a.client.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			unique[TopicPartition{Topic: topic, Partition: partition}] = true
+		}
+	}
+	for _, tp := range reset.Partitions {
+		partitions, err := a.client.Partitions(tp.Topic)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, partition := range partitions {
+			found = found || partition == tp.Partition
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s/%d", sarama.ErrUnknownTopicOrPartition, tp.Topic, tp.Partition)
+		}
+		unique[tp] = true
+	}
+	if len(unique) == 0 {
+		return nil, fmt.Errorf("%w: no partitions to reset", ErrInvalidOffsetReset)
+	}
+
+	partitions := make([]TopicPartition, 0, len(unique))
+	for tp := range unique {
+		partitions = append(partitions, tp)
+	}
+	sortTopicPartitions(partitions)
+	return partitions, nil
+}
+
+func (a *Admin) resetTarget(tp TopicPartition, current int64, reset OffsetReset) (int64, error) {
+	earliest, err := a.client.GetOffset(tp.Topic, tp.Partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	latest, err := a.client.GetOffset(tp.Topic, tp.Partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+
+	var target int64
+	switch reset.Mode {
+	case ResetToEarliest:
+		target = earliest
+	case ResetToLatest:
+		target = latest
+	case ResetToDatetime:
+		target, err = a.client.GetOffset(tp.Topic, tp.Partition, reset.Datetime.UnixMilli())
+		if err != nil {
+			return 0, err
+		}
+		// no record since then
+		if target < 0 {
+			target = latest
+		}
+	case ResetShiftBy:
+		if current < 0 {
+			return 0, fmt.Errorf("%w: no committed offset to shift on %s/%d", ErrInvalidOffsetReset, tp.Topic, tp.Partition)
+		}
+		target = current + reset.Shift
+	case ResetToExplicitOffset:
+		target = reset.Offset
+	default:
+		return 0, fmt.Errorf("%w: unknown mode %q", ErrInvalidOffsetReset, reset.Mode)
+	}
+
+	if target < earliest {
+		return earliest, nil
+	}
+	if target > latest {
+		return latest, nil
+	}
+	return target, nil
+}
+
+func (a *Admin) checkCommitted(plan *OffsetResetPlan) error {
+	request := make(map[string][]int32)
+	for _, partition := range plan.Partitions {
+		request[partition.Topic] = append(request[partition.Topic], partition.Partition)
+	}
+	committed, err := a.admin.ListConsumerGroupOffsets(plan.Group, request)
+	if err != nil {
+		return err
+	}
+	var missed []string
+	for _, partition := range plan.Partitions {
+		block := committed.GetBlock(partition.Topic, partition.Partition)
+		if block == nil || block.Offset != partition.Target {
+			missed = append(missed, fmt.Sprintf("%s/%d", partition.Topic, partition.Partition))
+		}
+	}
+	if len(missed) > 0 {
+		return fmt.Errorf("offsets of %s were not committed", strings.Join(missed, ", "))
+	}
+	return nil
+}
+
+func resetTopicPartitions(partitions []PartitionReset) []TopicPartition {
+	result := make([]TopicPartition, len(partitions))
+	for i, partition := range partitions {
+		result[i] = TopicPartition{Topic: partition.Topic, Partition: partition.Partition}
+	}
+	return result
+}
+
+// resetFingerprint identifies a reset by what it asks for, so a token only
+// executes the reset it was previewed for. partitions must be sorted.
+func resetFingerprint(group string, reset OffsetReset, partitions []TopicPartition) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", group, reset.Mode)
+	switch reset.Mode {
+	case ResetToDatetime:
+		fmt.Fprintf(hash, "%d\n", reset.Datetime.UnixMilli())
+	case ResetShiftBy:
+		fmt.Fprintf(hash, "%d\n", reset.Shift)
+	case ResetToExplicitOffset:
+		fmt.Fprintf(hash, "%d\n", reset.Offset)
+	}
+	for _, tp := range partitions {
+		fmt.Fprintf(hash, "%s/%d\n", tp.Topic, tp.Partition)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,141 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// groupOffsetManager commits to the offsets held by the mock admin
+type groupOffsetManager struct {
+	mockOffsetManager
+	group string
+	admin *mockGroupAdmin
+}
+
+func (om *groupOffsetManager) ManagePartition(topic string, partition int32) (sarama.PartitionOffsetManager, error) {
+	pom := &mockPartitionOffsetManager{offset: -1}
+	if offset, ok := om.admin.offsets[om.group][topic][partition]; ok {
+		pom.offset = offset
+	}
+	om.partitions[TopicPartition{Topic: topic, Partition: partition}] = pom
+	return pom, nil
+}
+
+func (om *groupOffsetManager) Commit() {
+	for tp, pom := range om.partitions {
+		if om.admin.offsets[om.group][tp.Topic] == nil {
+			om.admin.offsets[om.group][tp.Topic] = make(map[int32]int64)
+		}
+		om.admin.offsets[om.group][tp.Topic][tp.Partition] = pom.offset
+	}
+}
+
+func newResetAdmin(t *testing.T) (*Admin, *mockGroupAdmin) {
+	admin, clusterAdmin := newGroupAdmin(t)
+	admin.offsetManagers = func(group string, client sarama.Client) (sarama.OffsetManager, error) {
+		return &groupOffsetManager{
+			mockOffsetManager: mockOffsetManager{partitions: make(map[TopicPartition]*mockPartitionOffsetManager)},
+			group:             group,
+			admin:             clusterAdmin,
+		}, nil
+	}
+	return admin, clusterAdmin
+}
+
+func TestResetOffsets(t *testing.T) {
+	admin, clusterAdmin := newResetAdmin(t)
+
+	reset := OffsetReset{Mode: ResetToEarliest, Topics: []string{"orders"}}
+	plan, err := admin.PreviewOffsetReset("audit", reset)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, plan.ActiveMembers)
+	assert.Equal(t, []PartitionReset{
+		{Topic: "orders", Partition: 0, Current: 10, Target: 5},
+		{Topic: "orders", Partition: 1, Current: -1, Target: 0},
+	}, plan.Partitions)
+	assert.Equal(t, int64(10), clusterAdmin.offsets["audit"]["orders"][0], "previews commit nothing")
+
+	_, err = admin.ResetOffsets("audit", reset, "")
+	assert.ErrorIs(t, err, ErrResetNotPreviewed)
+	_, err = admin.ResetOffsets("audit", reset, resetFingerprint("audit", reset, []TopicPartition{{Topic: "orders", Partition: 0}, {Topic: "orders", Partition: 1}}))
+	assert.ErrorIs(t, err, ErrResetNotPreviewed, "tokens can't be worked out by the client")
+
+	_, err = admin.ResetOffsets("audit", reset, plan.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), clusterAdmin.offsets["audit"]["orders"][0])
+	assert.Equal(t, int64(0), clusterAdmin.offsets["audit"]["orders"][1])
+
+	_, err = admin.ResetOffsets("audit", reset, plan.Token)
+	assert.ErrorIs(t, err, ErrResetNotPreviewed, "tokens are used once")
+}
+
+func TestResetOffsetsChecksThePreview(t *testing.T) {
+	admin, clusterAdmin := newResetAdmin(t)
+	reset := OffsetReset{Mode: ResetToEarliest, Topics: []string{"orders"}}
+
+	plan, err := admin.PreviewOffsetReset("audit", reset)
+	assert.NoError(t, err)
+	_, err = admin.ResetOffsets("audit", OffsetReset{Mode: ResetToLatest, Topics: []string{"orders"}}, plan.Token)
+	assert.ErrorIs(t, err, ErrResetNotPreviewed, "the token belongs to another reset")
+
+	plan, err = admin.PreviewOffsetReset("audit", reset)
+	assert.NoError(t, err)
+	_, err = admin.ResetOffsets("billing", reset, plan.Token)
+	assert.ErrorIs(t, err, ErrResetNotPreviewed, "the token belongs to another group")
+
+	admin.previewTTL = -time.Second
+	plan, err = admin.PreviewOffsetReset("audit", reset)
+	assert.NoError(t, err)
+	_, err = admin.ResetOffsets("audit", reset, plan.Token)
+	assert.ErrorIs(t, err, ErrResetNotPreviewed, "the preview expired")
+	admin.previewTTL = resetPreviewTTL
+
+	plan, err = admin.PreviewOffsetReset("audit", reset)
+	assert.NoError(t, err)
+	clusterAdmin.offsets["audit"]["orders"][0] = 12
+	_, err = admin.ResetOffsets("audit", reset, plan.Token)
+	assert.ErrorIs(t, err, ErrResetStale)
+	assert.Equal(t, int64(12), clusterAdmin.offsets["audit"]["orders"][0])
+}
+
+func TestPreviewOffsetResetModes(t *testing.T) {
+	admin, _ := newResetAdmin(t)
+	orders0 := []TopicPartition{{Topic: "orders", Partition: 0}}
+
+	for _, test := range []struct {
+		reset  OffsetReset
+		target int64
+	}{
+		{OffsetReset{Mode: ResetToLatest, Partitions: orders0}, 100},
+		{OffsetReset{Mode: ResetToDatetime, Partitions: orders0, Datetime: time.Now()}, 40},
+		{OffsetReset{Mode: ResetToDatetime, Partitions: []TopicPartition{{Topic: "orders", Partition: 1}}, Datetime: time.Now()}, 100},
+		{OffsetReset{Mode: ResetShiftBy, Partitions: orders0, Shift: -3}, 7},
+		{OffsetReset{Mode: ResetShiftBy, Partitions: orders0, Shift: 1000}, 100},
+		{OffsetReset{Mode: ResetToExplicitOffset, Partitions: orders0, Offset: 1}, 5},
+	} {
+		plan, err := admin.PreviewOffsetReset("audit", test.reset)
+		assert.NoError(t, err, test.reset.Mode)
+		assert.Equal(t, test.target, plan.Partitions[0].Target, test.reset.Mode)
+	}
+
+	_, err := admin.PreviewOffsetReset("audit", OffsetReset{Mode: ResetShiftBy, Partitions: []TopicPartition{{Topic: "orders", Partition: 1}}, Shift: 1})
+	assert.ErrorIs(t, err, ErrInvalidOffsetReset, "there is no committed offset to shift")
+	_, err = admin.PreviewOffsetReset("audit", OffsetReset{Mode: ResetToEarliest, Partitions: []TopicPartition{{Topic: "orders", Partition: 7}}})
+	assert.ErrorIs(t, err, sarama.ErrUnknownTopicOrPartition)
+}
+
+func TestResetOffsetsRefusesActiveGroups(t *testing.T) {
+	admin, clusterAdmin := newResetAdmin(t)
+
+	reset := OffsetReset{Mode: ResetToEarliest, Topics: []string{"orders"}}
+	plan, err := admin.PreviewOffsetReset("billing", reset)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, plan.ActiveMembers)
+
+	_, err = admin.ResetOffsets("billing", reset, plan.Token)
+	assert.ErrorIs(t, err, ErrGroupActive)
+	assert.Equal(t, int64(90), clusterAdmin.offsets["billing"]["orders"][0])
+}
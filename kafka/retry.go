@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+var ErrProducerClosed = errors.New("producer closed before the message could be retried")
+
+// RetryPolicy says how often an async message that failed is sent again, on
+// top of the retries sarama makes within a single attempt
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt, 1 never retries
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the wait before the next attempt, it doubles on every attempt
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1, MinBackoff: time.Second, MaxBackoff: 30 * time.Second}
+}
+
+// Backoff is the wait after the attempt, doubling from MinBackoff up to MaxBackoff
+func (r RetryPolicy) Backoff(attempt int) time.Duration {
+	wait := r.MinBackoff
+	for i := 1; i < attempt && wait < r.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > r.MaxBackoff {
+		wait = r.MaxBackoff
+	}
+	return wait
+}
+
+// DeadLetter is an async message that still failed after the last attempt
+type DeadLetter struct {
+	Message Message
+	Err     error
+	// Partition is the partition of the last attempt, -1 if none was picked
+	Partition    int32
+	Attempts     int
+	FirstAttempt time.Time
+	LastAttempt  time.Time
+}
+
+// DeadLetterHandler takes the async messages given up on
+type DeadLetterHandler func(DeadLetter)
+
+// delivery follows an async message across its attempts, it travels as the
+// metadata of the producer message
+type delivery struct {
+	message  Message
+	attempts int
+	first    time.Time
+}
+
+// SetRetryPolicy sets how failed async messages are retried and who gets
+// the ones given up on. Without a handler they are only logged.
+func (p *Producer) SetRetryPolicy(policy RetryPolicy, handler DeadLetterHandler) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxBackoff < policy.MinBackoff {
+		policy.MaxBackoff = policy.MinBackoff
+	}
+	p.retryMutex.Lock()
+	defer p.retryMutex.Unlock()
+	p.retryPolicy, p.deadLetters = policy, handler
+}
+
+// dispatch collects the outcome of async messages until the async producer is closed
+func (p *Producer) dispatch() {
+	successes, failures := p.asyncProducer.Successes(), p.asyncProducer.Errors()
+	for successes != nil || failures != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			p.delivered(msg)
+		case producerError, ok := <-failures:
+			if !ok {
+				failures = nil
+				continue
+			}
+			p.failed(producerError)
+		}
+	}
+}
+
+// delivered reports the message of a successful async attempt
+func (p *Producer) delivered(msg *sarama.ProducerMessage) {
+	d, ok := msg.Metadata.(*delivery)
+	if !ok {
+		return
+	}
+	d.attempts++
+	p.reports.delivered(d.message, RecordMetadata{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+	}, d.attempts)
+}
+
+// failed retries the message of a failed async attempt or gives up on it
+func (p *Producer) failed(producerError *sarama.ProducerError) {
+	d, ok := producerError.Msg.Metadata.(*delivery)
+	if !ok {
+		log.Printf("failed to produce message: %v\n", producerError.Err)
+		return
+	}
+	d.attempts++
+
+	p.retryMutex.Lock()
+	defer p.retryMutex.Unlock()
+
+	if !p.closed && d.attempts < p.retryPolicy.MaxAttempts && retriable(producerError.Err) {
+		wait := p.retryPolicy.Backoff(d.attempts)
+		p.reports.pending(d.message, d.attempts, producerError.Err)
+		p.retries.Add(1)
+		go func() {
+			defer p.retries.Done()
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				msg := d.message.producerMessage()
+				msg.Metadata = d
+				p.asyncProducer.Input() <- msg
+			case <-p.closing:
+				p.retryMutex.Lock()
+				defer p.retryMutex.Unlock()
+				p.giveUp(d, ErrProducerClosed, -1)
+			}
+		}()
+		return
+	}
+	p.giveUp(d, producerError.Err, producerError.Msg.Partition)
+}
+
+// giveUp hands the message to the dead letter handler, p.retryMutex must be held
+func (p *Producer) giveUp(d *delivery, err error, partition int32) {
+	log.Printf("failed to produce message to topic %s after %d attempts: %v\n", d.message.Topic, d.attempts, err)
+	p.reports.failed(d.message, err, d.attempts)
+	if p.deadLetters == nil {
+		return
+	}
+	deadLetter := DeadLetter{
+		Message:      d.message,
+		Err:          err,
+		Partition:    partition,
+		Attempts:     d.attempts,
+		FirstAttempt: d.first,
+		LastAttempt:  time.Now().UTC(),
+	}
+	// the handler may produce itself, it must not hold up the dispatcher
+	go p.deadLetters(deadLetter)
+}
+
+// retriable reports whether sending the message again may succeed
+func retriable(err error) bool {
+	switch {
+	case errors.Is(err, sarama.ErrMessageSizeTooLarge),
+		errors.Is(err, sarama.ErrInvalidTopic),
+		errors.Is(err, sarama.ErrInvalidMessage),
+		errors.Is(err, sarama.ErrInvalidRecord),
+		errors.Is(err, sarama.ErrInvalidTimestamp),
+		errors.Is(err, sarama.ErrInvalidPartition),
+		errors.Is(err, sarama.ErrTopicAuthorizationFailed),
+		errors.Is(err, sarama.ErrClusterAuthorizationFailed):
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,60 @@
+package kafka
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MinBackoff: time.Second, MaxBackoff: time.Minute}
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, 8*time.Second, policy.Backoff(4))
+	assert.Equal(t, time.Minute, policy.Backoff(30))
+}
+
+func TestSendAsyncRetries(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mockAsyncProducer := mocks.NewAsyncProducer(t, config)
+	producer := &Producer{
+		syncProducer:  mocks.NewSyncProducer(t, nil),
+		asyncProducer: mockAsyncProducer,
+		mutex:         &sync.Mutex{},
+		reports:       NewDeliveryReports(time.Minute, 10),
+		closing:       make(chan struct{}),
+	}
+	deadLetters := make(chan DeadLetter, 2)
+	producer.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, func(deadLetter DeadLetter) {
+		deadLetters <- deadLetter
+	})
+	go producer.dispatch()
+
+	// a retriable failure is tried again until the last attempt
+	mockAsyncProducer.ExpectInputAndFail(sarama.ErrNotEnoughReplicas)
+	mockAsyncProducer.ExpectInputAndFail(sarama.ErrNotEnoughReplicas)
+	mockAsyncProducer.ExpectInputAndFail(sarama.ErrNotEnoughReplicas)
+	producer.SendAsync(Message{ID: "order-1", Topic: "orders", Value: []byte("created")})
+	deadLetter := <-deadLetters
+	assert.Equal(t, "orders", deadLetter.Message.Topic)
+	assert.Equal(t, 3, deadLetter.Attempts)
+	assert.ErrorIs(t, deadLetter.Err, sarama.ErrNotEnoughReplicas)
+	report, ok := producer.DeliveryReport("order-1")
+	assert.True(t, ok)
+	assert.Equal(t, DeliveryFailed, report.Status)
+	assert.Equal(t, 3, report.Attempts)
+	assert.Equal(t, sarama.ErrNotEnoughReplicas.Error(), report.Error)
+
+	// an error sending again can't fix is given up on at once
+	mockAsyncProducer.ExpectInputAndFail(sarama.ErrMessageSizeTooLarge)
+	producer.SendAsync(Message{Topic: "images", Value: []byte("large")})
+	deadLetter = <-deadLetters
+	assert.Equal(t, "images", deadLetter.Message.Topic)
+	assert.Equal(t, 1, deadLetter.Attempts)
+
+	assert.NoError(t, producer.Close())
+}
@@ -0,0 +1,205 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Stream reads every partition of a topic and hands the records out on a
+// single channel until it is closed. It is the building block of the live
+// delivery paths, unlike ConsumerInstance it never commits offsets.
+type Stream struct {
+	Topic string
+
+	client     sarama.Client
+	consumer   sarama.Consumer
+	partitions []int32
+	// starts holds the offset each partition started at
+	starts  map[int32]int64
+	records chan *Record
+	closing chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// Position tells where a stream starts reading each partition
+type Position struct {
+	// Offsets maps partitions to the next offset to read
+	Offsets map[int32]int64
+	// Timestamp is where partitions without an offset start, zero means the newest offset
+	Timestamp time.Time
+}
+
+// NewStream starts reading the topic from the newest offset
+func NewStream(brokers []string, conf *sarama.Config, factory ConsumerFactory, topic string) (*Stream, error) {
+	return NewStreamFrom(brokers, conf, factory, topic, Position{})
+}
+
+// NewStreamFrom starts reading the topic from the given position. Offsets
+// that are no longer available fall back to the oldest one.
+func NewStreamFrom(brokers []string, conf *sarama.Config, factory ConsumerFactory, topic string, from Position) (*Stream, error) {
+	var config *sarama.Config
+	if conf == nil {
+		config = sarama.NewConfig()
+	} else {
+		config = conf
+	}
+
+	client, consumer, err := factory(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start consumer: %w", err)
+	}
+
+	s := &Stream{
+		Topic:    topic,
+		client:   client,
+		consumer: consumer,
+		records:  make(chan *Record, config.ChannelBufferSize),
+		closing:  make(chan struct{}),
+	}
+
+	partitions, err := consumer.Partitions(topic)
+	if err != nil {
+		_ = s.release()
+		return nil, fmt.Errorf("failed to get partitions for topic %s: %w", topic, err)
+	}
+
+	s.partitions = partitions
+	s.starts = make(map[int32]int64, len(partitions))
+
+	for _, partition := range partitions {
+		offset, err := s.startOffset(partition, from)
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+		pc, err := consumer.ConsumePartition(topic, partition, offset)
+		if errors.Is(err, sarama.ErrOffsetOutOfRange) {
+			offset, err = client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err == nil {
+				pc, err = consumer.ConsumePartition(topic, partition, offset)
+			}
+		}
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("failed to consume %s/%d: %w", topic, partition, err)
+		}
+		s.starts[partition] = offset
+		s.forward(pc)
+	}
+
+	log.Printf("stream started for topic %s", topic)
+	return s, nil
+}
+
+// startOffset resolves where the partition starts. The end of the log is
+// looked up before consuming, so records produced while the stream starts
+// are read rather than counted as seen.
+func (s *Stream) startOffset(partition int32, from Position) (int64, error) {
+	if offset, ok := from.Offsets[partition]; ok {
+		return offset, nil
+	}
+	if !from.Timestamp.IsZero() {
+		offset, err := s.client.GetOffset(s.Topic, partition, from.Timestamp.UnixMilli())
+		if err != nil {
+			return 0, fmt.Errorf("failed to look up offset for %s/%d: %w", s.Topic, partition, err)
+		}
+		if offset >= 0 {
+			return offset, nil
+		}
+	}
+	offset, err := s.client.GetOffset(s.Topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up high water mark for %s/%d: %w", s.Topic, partition, err)
+	}
+	return offset, nil
+}
+
+// StartOffsets returns the offset each partition started at
+func (s *Stream) StartOffsets() map[int32]int64 {
+	starts := make(map[int32]int64, len(s.starts))
+	for partition, offset := range s.starts {
+		starts[partition] = offset
+	}
+	return starts
+}
+
+// SeenOffsets returns the offset before the one each partition started at,
+// the last one seen by a reader that is about to get the first record.
+// Offsets are -1 for partitions starting at offset 0.
+func (s *Stream) SeenOffsets() map[int32]int64 {
+	seen := make(map[int32]int64, len(s.starts))
+	for partition, offset := range s.starts {
+		seen[partition] = offset - 1
+	}
+	return seen
+}
+
+// HighWaterMarks returns the offset the next record of each partition will get
+func (s *Stream) HighWaterMarks() (map[int32]int64, error) {
+	marks := make(map[int32]int64, len(s.partitions))
+	for _, partition := range s.partitions {
+		offset, err := s.client.GetOffset(s.Topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up high water mark for %s/%d: %w", s.Topic, partition, err)
+		}
+		marks[partition] = offset
+	}
+	return marks, nil
+}
+
+// Records returns the channel the records are delivered on. It is closed once the stream is closed.
+func (s *Stream) Records() <-chan *Record {
+	return s.records
+}
+
+func (s *Stream) forward(pc sarama.PartitionConsumer) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer pc.AsyncClose()
+		for {
+			select {
+			case msg, ok := <-pc.Messages():
+				if !ok {
+					return
+				}
+				select {
+				case s.records <- newRecord(msg):
+				case <-s.closing:
+					return
+				}
+			case <-s.closing:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops reading and releases the sarama client
+func (s *Stream) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closing)
+		s.wg.Wait()
+		close(s.records)
+		err = s.release()
+		log.Printf("stream stopped for topic %s", s.Topic)
+	})
+	return err
+}
+
+func (s *Stream) release() error {
+	if err := s.consumer.Close(); err != nil {
+		return fmt.Errorf("failed to close consumer: %w", err)
+	}
+	if err := s.client.Close(); err != nil {
+		return fmt.Errorf("failed to close client: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	// Given
+	mockConsumer := mocks.NewConsumer(t, nil)
+	mockConsumer.SetTopicMetadata(map[string][]int32{"test-topic": {0, 1}})
+	mockConsumer.ExpectConsumePartition("test-topic", 0, 7).
+		YieldMessage(&sarama.ConsumerMessage{
+			Key:     []byte("test-key"),
+			Value:   []byte("test-value"),
+			Headers: []*sarama.RecordHeader{{Key: []byte("tenant"), Value: []byte("acme")}},
+		})
+	mockConsumer.ExpectConsumePartition("test-topic", 1, 7)
+
+	factory := func(brokers []string, config *sarama.Config) (sarama.Client, sarama.Consumer, error) {
+		return offsetClient{newest: 7}, mockConsumer, nil
+	}
+
+	// When
+	stream, err := NewStream(nil, mocks.NewTestConfig(), factory, "test-topic")
+	assert.NoError(t, err)
+
+	// Then
+	select {
+	case record := <-stream.Records():
+		assert.Equal(t, "test-topic", record.Topic)
+		assert.Equal(t, "test-key", string(record.Key))
+		assert.Equal(t, "test-value", string(record.Value))
+		assert.Equal(t, map[string]string{"tenant": "acme"}, record.Headers)
+	case <-time.After(time.Second):
+		t.Fatal("no record received from the stream")
+	}
+
+	assert.NoError(t, stream.Close())
+	_, ok := <-stream.Records()
+	assert.False(t, ok, "records channel should be closed")
+}
+
+// offsetClient answers offset lookups, the newest offset of every partition is newest
+type offsetClient struct {
+	mockClient
+	byTime map[int32]int64
+	newest int64
+}
+
+func (c offsetClient) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	if time == sarama.OffsetNewest {
+		return c.newest, nil
+	}
+	return c.byTime[partition], nil
+}
+
+func TestStreamFrom(t *testing.T) {
+	// Given
+	mockConsumer := mocks.NewConsumer(t, nil)
+	mockConsumer.SetTopicMetadata(map[string][]int32{"test-topic": {0, 1}})
+	mockConsumer.ExpectConsumePartition("test-topic", 0, 5).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("from-offset")})
+	mockConsumer.ExpectConsumePartition("test-topic", 1, 3).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("from-timestamp")})
+
+	client := offsetClient{byTime: map[int32]int64{1: 3}, newest: 10}
+	factory := func(brokers []string, config *sarama.Config) (sarama.Client, sarama.Consumer, error) {
+		return client, mockConsumer, nil
+	}
+
+	// When
+	stream, err := NewStreamFrom(nil, mocks.NewTestConfig(), factory, "test-topic", Position{
+		Offsets:   map[int32]int64{0: 5},
+		Timestamp: time.Now().Add(-time.Hour),
+	})
+	assert.NoError(t, err)
+
+	// Then
+	values := make([]string, 0, 2)
+	for len(values) < 2 {
+		select {
+		case record := <-stream.Records():
+			values = append(values, string(record.Value))
+		case <-time.After(time.Second):
+			t.Fatal("no record received from the stream")
+		}
+	}
+	assert.ElementsMatch(t, []string{"from-offset", "from-timestamp"}, values)
+
+	marks, err := stream.HighWaterMarks()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int32]int64{0: 10, 1: 10}, marks)
+	assert.Equal(t, map[int32]int64{0: 5, 1: 3}, stream.StartOffsets())
+	assert.NoError(t, stream.Close())
+}
+
+func TestStreamSeenOffsetsResume(t *testing.T) {
+	// Given a stream started live on two partitions, only one of which sent a record,
+	// which consumes from the high-water marks looked up before it started
+	first := mocks.NewConsumer(t, nil)
+	first.SetTopicMetadata(map[string][]int32{"test-topic": {0, 1}})
+	first.ExpectConsumePartition("test-topic", 0, 10).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("seen")})
+	first.ExpectConsumePartition("test-topic", 1, 10)
+	stream, err := NewStream(nil, mocks.NewTestConfig(), func(brokers []string, config *sarama.Config) (sarama.Client, sarama.Consumer, error) {
+		return offsetClient{newest: 10}, first, nil
+	}, "test-topic")
+	assert.NoError(t, err)
+
+	seen := stream.SeenOffsets()
+	assert.Equal(t, map[int32]int64{0: 9, 1: 9}, seen)
+	select {
+	case record := <-stream.Records():
+		seen[record.Partition] = record.Offset
+	case <-time.After(time.Second):
+		t.Fatal("no record received from the stream")
+	}
+	assert.NoError(t, stream.Close())
+
+	// When the reader reconnects after more records were produced
+	second := mocks.NewConsumer(t, nil)
+	second.SetTopicMetadata(map[string][]int32{"test-topic": {0, 1}})
+	second.ExpectConsumePartition("test-topic", 0, seen[0]+1)
+	// Then the partition never seen resumes where the first stream started, not at the end
+	second.ExpectConsumePartition("test-topic", 1, 10)
+	from := Position{Offsets: make(map[int32]int64, len(seen))}
+	for partition, offset := range seen {
+		from.Offsets[partition] = offset + 1
+	}
+	stream, err = NewStreamFrom(nil, mocks.NewTestConfig(), func(brokers []string, config *sarama.Config) (sarama.Client, sarama.Consumer, error) {
+		return offsetClient{newest: 12}, second, nil
+	}, "test-topic", from)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+}
@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type configKind int
+
+const (
+	configLong configKind = iota
+	configInt
+	configDouble
+	configBool
+	configString
+	// configList is a comma separated list of values
+	configList
+)
+
+type topicConfig struct {
+	kind configKind
+	// min is the smallest value of numeric configs
+	min float64
+	// values lists the accepted values, any value is fine when empty
+	values []string
+}
+
+// topicConfigs are the configs a topic may override, as of Kafka 3.4
+var topicConfigs = map[string]topicConfig{
+	"cleanup.policy":       {kind: configList, values: []string{"compact", "delete"}},
+	"compression.type":     {kind: configString, values: []string{"uncompressed", "zstd", "lz4", "snappy", "gzip", "producer"}},
+	"delete.retention.ms":  {kind: configLong},
+	"file.delete.delay.ms": {kind: configLong},
+	"flush.messages":       {kind: configLong, min: 1},
+	"flush.ms":             {kind: configLong},
+	"follower.replication.throttled.replicas": {kind: configList},
+	"index.interval.bytes":                    {kind: configInt},
+	"leader.replication.throttled.replicas":   {kind: configList},
+	"local.retention.bytes":                   {kind: configLong, min: -2},
+	"local.retention.ms":                      {kind: configLong, min: -2},
+	"max.compaction.lag.ms":                   {kind: configLong, min: 1},
+	"max.message.bytes":                       {kind: configInt},
+	"message.downconversion.enable":           {kind: configBool},
+	"message.format.version":                  {kind: configString},
+	"message.timestamp.difference.max.ms":     {kind: configLong},
+	"message.timestamp.type":                  {kind: configString, values: []string{"CreateTime", "LogAppendTime"}},
+	"min.cleanable.dirty.ratio":               {kind: configDouble},
+	"min.compaction.lag.ms":                   {kind: configLong},
+	"min.insync.replicas":                     {kind: configInt, min: 1},
+	"preallocate":                             {kind: configBool},
+	"remote.storage.enable":                   {kind: configBool},
+	"retention.bytes":                         {kind: configLong, min: -1},
+	"retention.ms":                            {kind: configLong, min: -1},
+	"segment.bytes":                           {kind: configInt, min: 14},
+	"segment.index.bytes":                     {kind: configInt, min: 4},
+	"segment.jitter.ms":                       {kind: configLong},
+	"segment.ms":                              {kind: configLong, min: 1},
+	"unclean.leader.election.enable":          {kind: configBool},
+}
+
+// ValidateTopicConfig checks that a topic may set the config to the value.
+// The broker has the last word, this catches typos before they reach it.
+func ValidateTopicConfig(name string, value string) error {
+	config, ok := topicConfigs[name]
+	if !ok {
+		return fmt.Errorf("unknown topic config %q", name)
+	}
+
+	var err error
+	var number float64
+	switch config.kind {
+	case configLong:
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 64)
+		number = float64(n)
+	case configInt:
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 32)
+		number = float64(n)
+	case configDouble:
+		number, err = strconv.ParseFloat(value, 64)
+		if err == nil && (number < 0 || number > 1) {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	case configBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%s must be true or false", name)
+		}
+	case configList:
+		for _, item := range strings.Split(value, ",") {
+			if err := checkConfigValue(name, config, strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+	case configString:
+		return checkConfigValue(name, config, value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s must be a number: %q", name, value)
+	}
+	if config.kind == configLong || config.kind == configInt {
+		if number < config.min {
+			return fmt.Errorf("%s must be at least %v", name, config.min)
+		}
+	}
+	return nil
+}
+
+// IsTopicConfig reports whether a topic may override the config
+func IsTopicConfig(name string) bool {
+	_, ok := topicConfigs[name]
+	return ok
+}
+
+func checkConfigValue(name string, config topicConfig, value string) error {
+	if len(config.values) == 0 {
+		return nil
+	}
+	for _, accepted := range config.values {
+		if value == accepted {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %s %q, expected one of %s", name, value, strings.Join(config.values, ", "))
+}
@@ -0,0 +1,97 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+var ErrTransactionsDisabled = errors.New("transactions are not enabled")
+
+// TransactionalProducer writes batches of records, possibly to several
+// topics, in Kafka transactions: consumers reading committed records see
+// either all of a batch or none of it.
+type TransactionalProducer struct {
+	producer sarama.SyncProducer
+	// mutex keeps to one transaction at a time, the producer can't run more
+	mutex sync.Mutex
+}
+
+// NewTransactionalProducer creates a producer using transactionalID, which
+// must be unique to this instance of the gateway: a second producer with the
+// same id fences off the first one.
+func NewTransactionalProducer(brokers []string, conf *sarama.Config, transactionalID string, factory ProducerFactory) (*TransactionalProducer, error) {
+	if transactionalID == "" {
+		return nil, errors.New("transactional id is missing")
+	}
+	config := producerConfig(conf)
+	EnableIdempotence(config)
+	config.Producer.Transaction.ID = transactionalID
+
+	syncProducer, asyncProducer, err := factory(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transactional producer: %w", err)
+	}
+	if asyncProducer != nil {
+		if err := asyncProducer.Close(); err != nil {
+			log.Printf("failed to close unused producer: %v", err)
+		}
+	}
+	return &TransactionalProducer{producer: syncProducer}, nil
+}
+
+// SendTransaction produces the messages in one transaction. It either returns
+// where every record was written or aborts the transaction and returns why.
+func (p *TransactionalProducer) SendTransaction(messages []Message) ([]RecordMetadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.producer.BeginTxn(); err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	msgs := make([]*sarama.ProducerMessage, len(messages))
+	for i, message := range messages {
+		msgs[i] = message.producerMessage()
+	}
+	if err := p.producer.SendMessages(msgs); err != nil {
+		var errs sarama.ProducerErrors
+		if errors.As(err, &errs) && len(errs) > 0 {
+			err = errs[0].Err
+		}
+		return nil, p.abort(err)
+	}
+	if err := p.producer.CommitTxn(); err != nil {
+		return nil, p.abort(fmt.Errorf("failed to commit transaction: %w", err))
+	}
+
+	metadata := make([]RecordMetadata, len(msgs))
+	for i, msg := range msgs {
+		metadata[i] = RecordMetadata{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Timestamp: msg.Timestamp,
+		}
+	}
+	return metadata, nil
+}
+
+// abort rolls back the running transaction after err, unless the producer hit
+// a fatal error and can't abort any more
+func (p *TransactionalProducer) abort(err error) error {
+	if p.producer.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0 {
+		return fmt.Errorf("transaction failed and the producer is unusable: %w", err)
+	}
+	if abortErr := p.producer.AbortTxn(); abortErr != nil {
+		log.Printf("failed to abort transaction: %v", abortErr)
+	}
+	return err
+}
+
+func (p *TransactionalProducer) Close() error {
+	return p.producer.Close()
+}
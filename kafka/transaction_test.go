@@ -0,0 +1,61 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionalProducer(t *testing.T) {
+	var mockSyncProducer *mocks.SyncProducer
+	var config *sarama.Config
+	factory := func(brokers []string, conf *sarama.Config) (sarama.SyncProducer, sarama.AsyncProducer, error) {
+		config = conf
+		// the mock fails sends made outside a transaction when the config is transactional
+		mockSyncProducer = mocks.NewSyncProducer(t, conf)
+		return mockSyncProducer, nil, nil
+	}
+
+	producer, err := NewTransactionalProducer(nil, nil, "gateway-1", factory)
+	assert.NoError(t, err)
+	assert.True(t, config.Producer.Idempotent)
+	assert.Equal(t, "gateway-1", config.Producer.Transaction.ID)
+	assert.True(t, mockSyncProducer.IsTransactional())
+
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	metadata, err := producer.SendTransaction([]Message{
+		{Topic: "orders", Key: []byte("42"), Value: []byte("created")},
+		{Topic: "payments", Value: []byte("charged")},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, metadata, 2)
+	assert.Equal(t, "payments", metadata[1].Topic)
+	assert.Equal(t, int64(2), metadata[1].Offset)
+	assert.Equal(t, sarama.ProducerTxnFlagReady, mockSyncProducer.TxnStatus(), "the transaction should be committed")
+
+	mockSyncProducer.ExpectSendMessageAndSucceed()
+	mockSyncProducer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+	_, err = producer.SendTransaction([]Message{
+		{Topic: "orders", Value: []byte("created")},
+		{Topic: "payments", Value: []byte("charged")},
+	})
+	assert.ErrorIs(t, err, sarama.ErrNotEnoughReplicas)
+	assert.Equal(t, sarama.ProducerTxnFlagReady, mockSyncProducer.TxnStatus(), "the transaction should be aborted")
+
+	assert.NoError(t, producer.Close())
+
+	_, err = NewTransactionalProducer(nil, nil, "", factory)
+	assert.Error(t, err)
+}
+
+func TestEnableIdempotence(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_10_2_0
+	EnableIdempotence(config)
+	config = producerConfig(config)
+	assert.NoError(t, config.Validate())
+	assert.True(t, config.Version.IsAtLeast(sarama.V0_11_0_0))
+}
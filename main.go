@@ -13,9 +13,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/cploutarchou/go-kafka-rest/controllers"
+	"github.com/cploutarchou/go-kafka-rest/deadletter"
 	"github.com/cploutarchou/go-kafka-rest/initializers"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
 	middlewares "github.com/cploutarchou/go-kafka-rest/middleware"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/cploutarchou/go-kafka-rest/outbox"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -28,6 +33,12 @@ var (
 	middleware *middlewares.Middleware
 	config     *initializers.Config
 	brokers    []string
+	stopHub    context.CancelFunc
+	stopPurge  context.CancelFunc
+	stopRelay  context.CancelFunc
+
+	transactionalProducer *kafka.TransactionalProducer
+	admin                 *kafka.Admin
 )
 
 // setupApp initializes the fiber app, middleware, and controllers
@@ -45,8 +56,68 @@ func setupApp() (*fiber.App, error) {
 
 	// Initialize the middleware and controllers
 	middleware = middlewares.NewMiddleware(config, db)
+	var purgeCtx context.Context
+	purgeCtx, stopPurge = context.WithCancel(context.Background())
+	go middleware.PurgeIdempotencyKeys(purgeCtx, time.Hour)
 	brokers = strings.Split(config.KafkaBrokers, ",")
-	controller = controllers.NewController(db, brokers, int32(config.KafkaNumOfPartitions))
+	saramaConfig, err := producerConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka configuration: %s", err.Error())
+	}
+	// the producer is a singleton, the controllers get this one
+	producer, err := kafka.NewProducer(brokers, saramaConfig, kafka.TheProducerFactory, kafka.WithDeliveryReports(deliveryReports(config)))
+	if err != nil {
+		return nil, err
+	}
+	controller = controllers.NewController(db, brokers, int32(config.KafkaNumOfPartitions), saramaConfig)
+	if config.KafkaTransactionalID != "" {
+		txnConfig, err := producerConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kafka configuration: %s", err.Error())
+		}
+		transactionalProducer, err = kafka.NewTransactionalProducer(brokers, txnConfig, config.KafkaTransactionalID, kafka.TheProducerFactory)
+		if err != nil {
+			return nil, err
+		}
+		controller.SetTransactionalProducer(transactionalProducer)
+	}
+
+	adminConfig, err := adminConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka configuration: %s", err.Error())
+	}
+	admin, err = kafka.NewAdmin(brokers, adminConfig, kafka.TheAdminFactory, int32(config.KafkaNumOfPartitions), int16(config.KafkaReplicationFactor))
+	if err != nil {
+		return nil, err
+	}
+	controller.SetAdmin(admin)
+
+	deadLetters := deadletter.NewRouter(db, producer, config.KafkaDeadLetterTopic)
+	retryPolicy := kafka.DefaultRetryPolicy()
+	if config.KafkaRetryMaxAttempts > 0 {
+		retryPolicy.MaxAttempts = config.KafkaRetryMaxAttempts
+	}
+	if config.KafkaRetryMinBackoff > 0 {
+		retryPolicy.MinBackoff = config.KafkaRetryMinBackoff
+	}
+	if config.KafkaRetryMaxBackoff > 0 {
+		retryPolicy.MaxBackoff = config.KafkaRetryMaxBackoff
+	}
+	producer.SetRetryPolicy(retryPolicy, deadLetters.Handle)
+	controller.SetDeadLetterRouter(deadLetters)
+
+	if config.OutboxEnabled {
+		relay := outbox.NewRelay(db, producer, outbox.Options{
+			Interval:   config.OutboxRelayInterval,
+			BatchSize:  config.OutboxBatchSize,
+			MaxBackoff: config.OutboxMaxBackoff,
+			Retention:  config.OutboxRetention,
+		})
+		var relayCtx context.Context
+		relayCtx, stopRelay = context.WithCancel(context.Background())
+		go relay.Run(relayCtx)
+		controller.SetDurableProduce(true)
+	}
 
 	// Check if Kafka brokers are set
 	if config.KafkaBrokers == "" {
@@ -62,7 +133,7 @@ func setupApp() (*fiber.App, error) {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
 		AllowHeaders:     "Origin, Content-Type, Accept",
-		AllowMethods:     "GET, POST",
+		AllowMethods:     "GET, POST, PATCH, DELETE",
 		AllowCredentials: true,
 	}))
 
@@ -87,16 +158,52 @@ func setupApp() (*fiber.App, error) {
 	return app, nil
 }
 
+// producerConfig builds the sarama configuration of the producer
+func producerConfig(config *initializers.Config) (*sarama.Config, error) {
+	topics, err := kafka.ParseTopicPartitioners(config.KafkaTopicPartitioners)
+	if err != nil {
+		return nil, err
+	}
+	partitioner, err := kafka.NewTopicPartitioner(config.KafkaPartitioner, topics)
+	if err != nil {
+		return nil, err
+	}
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.Partitioner = partitioner
+	if config.KafkaIdempotent {
+		kafka.EnableIdempotence(saramaConfig)
+	}
+	return saramaConfig, nil
+}
+
+// deliveryReports keeps the delivery reports of the producer, zero settings take the defaults
+func deliveryReports(config *initializers.Config) *kafka.DeliveryReports {
+	return kafka.NewDeliveryReports(config.DeliveryReportTTL, config.DeliveryReportLimit)
+}
+
+// adminConfig is the sarama config of the cluster administration, nil takes
+// the defaults of the admin
+func adminConfig(config *initializers.Config) (*sarama.Config, error) {
+	if config.KafkaAdminVersion == "" {
+		return nil, nil
+	}
+	version, err := sarama.ParseKafkaVersion(config.KafkaAdminVersion)
+	if err != nil {
+		return nil, err
+	}
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.ClientID = "kafka-go"
+	saramaConfig.Version = version
+	return saramaConfig, nil
+}
+
 // setupRoutes sets up all the routes for the fiber app
 func setupRoutes(controller *controllers.Controller) *fiber.App {
 	app := fiber.New()
-	// Health check endpoint
-	app.Get("/healthchecker", func(c *fiber.Ctx) error {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"status":  "success",
-			"message": "JWT Authentication with Golang, Fiber, and GORM",
-		})
-	})
+	// Health check endpoints, /healthchecker is kept for existing probes
+	app.Get("/health/live", controller.Health.Live)
+	app.Get("/health/ready", controller.Health.Ready)
+	app.Get("/healthchecker", controller.Health.Live)
 
 	// Authentication endpoints
 	app.Route("/auth", func(router fiber.Router) {
@@ -109,19 +216,55 @@ func setupRoutes(controller *controllers.Controller) *fiber.App {
 	if config.EnableWebsocket {
 		// log that we are starting the hub and pass the logger to it
 		hub_ := hub.NewHub(brokers, logger_, nil)
-		ctx := context.Background()
-		ctx, cancel := context.WithCancel(ctx)
-		defer cancel()
+		policy, err := hub.ParseOverflowPolicy(config.WebsocketOverflowPolicy)
+		if err != nil {
+			log.Fatalf("invalid websocket configuration: %v", err)
+		}
+		hub_.SetOptions(hub.Options{
+			QueueSize:             config.WebsocketQueueSize,
+			OverflowPolicy:        policy,
+			PingInterval:          config.WebsocketPingInterval,
+			PongWait:              config.WebsocketPongTimeout,
+			WriteWait:             config.WebsocketWriteTimeout,
+			MaxMessageSize:        config.WebsocketMaxMessageSize,
+			MaxConnections:        config.WebsocketMaxConnections,
+			MaxConnectionsPerUser: config.WebsocketMaxConnectionsPerUser,
+			ClusterTopic:          config.WebsocketClusterTopic,
+			InstanceID:            config.WebsocketInstanceID,
+		})
+		// the producer must be up before clients connect
+		if err := hub_.Connect(); err != nil {
+			log.Fatalf("failed to connect the websocket hub to kafka: %v", err)
+		}
+		var ctx context.Context
+		ctx, stopHub = context.WithCancel(context.Background())
 		go hub_.Run(ctx, logger_)
 		app.Route("/kafka", func(router fiber.Router) {
-			router.Post("/send-message", middleware.DeserializeUser, controller.User.SendMessage)
+			router.Post("/send-message", middleware.DeserializeUser, middleware.Idempotency, controller.User.SendMessage)
+			router.Post("/send-messages", middleware.DeserializeUser, middleware.Idempotency, controller.User.SendMessages)
+			router.Post("/send-transaction", middleware.DeserializeUser, middleware.Idempotency, controller.User.SendTransaction)
+			router.Get("/messages/:id/status", middleware.DeserializeUser, controller.User.MessageStatus)
 			router.Get("/ws", middleware.DeserializeUser, websocket.New(func(c *websocket.Conn) {
 				hub_.UpgradeWebSocket(c, logger_)
 			}))
+			router.Get("/ws/stats", middleware.DeserializeUser, func(c *fiber.Ctx) error {
+				return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": hub_.Stats()})
+			})
+			setupConsumerRoutes(router, controller)
+			setupDeadLetterRoutes(router, controller)
+			setupAdminRoutes(router, controller)
+			setupGroupRoutes(router, controller)
 		})
 	} else {
 		app.Route("/kafka", func(router fiber.Router) {
-			router.Post("/send-message", middleware.DeserializeUser, controller.User.SendMessage)
+			router.Post("/send-message", middleware.DeserializeUser, middleware.Idempotency, controller.User.SendMessage)
+			router.Post("/send-messages", middleware.DeserializeUser, middleware.Idempotency, controller.User.SendMessages)
+			router.Post("/send-transaction", middleware.DeserializeUser, middleware.Idempotency, controller.User.SendTransaction)
+			router.Get("/messages/:id/status", middleware.DeserializeUser, controller.User.MessageStatus)
+			setupConsumerRoutes(router, controller)
+			setupDeadLetterRoutes(router, controller)
+			setupAdminRoutes(router, controller)
+			setupGroupRoutes(router, controller)
 		})
 	}
 
@@ -140,6 +283,59 @@ func setupRoutes(controller *controllers.Controller) *fiber.App {
 	return app
 }
 
+// setupConsumerRoutes sets up the consumer instance and topic stream routes under /kafka
+func setupConsumerRoutes(router fiber.Router, controller *controllers.Controller) {
+	router.Get("/topics/:topic/stream", middleware.DeserializeUser, controller.Stream.StreamTopic)
+	router.Route("/consumers/:group", func(router fiber.Router) {
+		router.Post("/", middleware.DeserializeUser, controller.Consumer.CreateInstance)
+		router.Delete("/instances/:instance", middleware.DeserializeUser, controller.Consumer.DeleteInstance)
+		router.Post("/instances/:instance/subscription", middleware.DeserializeUser, controller.Consumer.Subscribe)
+		router.Get("/instances/:instance/subscription", middleware.DeserializeUser, controller.Consumer.GetSubscription)
+		router.Delete("/instances/:instance/subscription", middleware.DeserializeUser, controller.Consumer.Unsubscribe)
+		router.Get("/instances/:instance/records", middleware.DeserializeUser, controller.Consumer.FetchRecords)
+		router.Post("/instances/:instance/auto-commit", middleware.DeserializeUser, controller.Consumer.SetAutoCommit)
+		router.Post("/instances/:instance/offsets", middleware.DeserializeUser, controller.Consumer.CommitOffsets)
+		router.Get("/instances/:instance/offsets", middleware.DeserializeUser, controller.Consumer.GetCommittedOffsets)
+		router.Post("/instances/:instance/positions", middleware.DeserializeUser, controller.Consumer.Seek)
+		router.Post("/instances/:instance/positions/beginning", middleware.DeserializeUser, controller.Consumer.SeekToBeginning)
+		router.Post("/instances/:instance/positions/end", middleware.DeserializeUser, controller.Consumer.SeekToEnd)
+		router.Post("/instances/:instance/positions/timestamp", middleware.DeserializeUser, controller.Consumer.SeekToTimestamp)
+	})
+}
+
+// setupDeadLetterRoutes sets up the routes listing and re-driving dead letters under /kafka
+func setupDeadLetterRoutes(router fiber.Router, controller *controllers.Controller) {
+	// dead letters hold the payloads of every user
+	admin := middleware.RequireRole(models.RoleAdmin)
+	router.Get("/dead-letters", middleware.DeserializeUser, admin, controller.DeadLetters.ListDeadLetters)
+	router.Post("/dead-letters/:id/redrive", middleware.DeserializeUser, admin, controller.DeadLetters.RedriveDeadLetter)
+}
+
+// setupAdminRoutes sets up the admin only cluster administration routes under /kafka
+func setupAdminRoutes(router fiber.Router, controller *controllers.Controller) {
+	admin := middleware.RequireRole(models.RoleAdmin)
+	router.Get("/topics", middleware.DeserializeUser, admin, controller.Admin.ListTopics)
+	router.Post("/topics", middleware.DeserializeUser, admin, controller.Admin.CreateTopic)
+	router.Get("/topics/:topic", middleware.DeserializeUser, admin, controller.Admin.DescribeTopic)
+	router.Delete("/topics/:topic", middleware.DeserializeUser, admin, controller.Admin.DeleteTopic)
+	router.Get("/topics/:topic/configs", middleware.DeserializeUser, admin, controller.Admin.DescribeTopicConfig)
+	router.Patch("/topics/:topic/configs", middleware.DeserializeUser, admin, controller.Admin.AlterTopicConfig)
+	router.Post("/topics/:topic/partitions", middleware.DeserializeUser, admin, controller.Admin.CreatePartitions)
+	router.Post("/consumer-groups/:group/offsets/reset", middleware.DeserializeUser, admin, controller.Admin.ResetGroupOffsets)
+	router.Get("/audit", middleware.DeserializeUser, admin, controller.Admin.ListAuditEntries)
+	router.Get("/cluster", middleware.DeserializeUser, admin, controller.Admin.DescribeCluster)
+}
+
+// setupGroupRoutes sets up the admin only consumer group inspection and lag routes under /kafka
+func setupGroupRoutes(router fiber.Router, controller *controllers.Controller) {
+	// groups expose the members, hosts and client ids of the whole cluster
+	admin := middleware.RequireRole(models.RoleAdmin)
+	router.Get("/consumer-groups", middleware.DeserializeUser, admin, controller.Groups.ListGroups)
+	router.Get("/consumer-groups/:group", middleware.DeserializeUser, admin, controller.Groups.DescribeGroup)
+	router.Get("/consumer-groups/:group/lag", middleware.DeserializeUser, admin, controller.Groups.GroupLag)
+	router.Get("/lag", middleware.DeserializeUser, admin, controller.Groups.LagSummary)
+}
+
 // main is the entry point of the application
 func main() {
 	// Setup the fiber app
@@ -179,6 +375,28 @@ func main() {
 	if err := app.Shutdown(); err != nil {
 		log.Fatal("server shutdown:", err)
 	}
+	if stopHub != nil {
+		stopHub()
+	}
+	if stopPurge != nil {
+		stopPurge()
+	}
+	if stopRelay != nil {
+		stopRelay()
+	}
+	if err := controller.Consumer.Consumer.Close(); err != nil {
+		log.Printf("consumer shutdown: %v", err)
+	}
+	if transactionalProducer != nil {
+		if err := transactionalProducer.Close(); err != nil {
+			log.Printf("transactional producer shutdown: %v", err)
+		}
+	}
+	if admin != nil {
+		if err := admin.Close(); err != nil {
+			log.Printf("cluster admin shutdown: %v", err)
+		}
+	}
 
 	log.Print("server exited")
 }
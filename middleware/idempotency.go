@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// IdempotencyKeyHeader carries the key a client picked for a request it may retry
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks responses replayed from an earlier request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// DefaultIdempotencyWindow is how long keys are remembered when not configured
+	DefaultIdempotencyWindow = 24 * time.Hour
+
+	maxIdempotencyKeyLength = 255
+	// maxIdempotencyClaims bounds the attempts to claim a key other requests keep taking
+	maxIdempotencyClaims = 3
+	// idempotencyLease is how long a request may hold a key without
+	// completing it, after that it is taken as abandoned, by a crash for instance
+	idempotencyLease = 2 * time.Minute
+)
+
+// Idempotency makes requests carrying an Idempotency-Key header safe to retry.
+// The first request with a key is handled and its response stored, retries
+// within the window get that response back instead of being handled again.
+// Reusing a key for a different request is rejected. Failures on our side
+// (5xx) aren't stored, so the request can be retried with the same key, and
+// neither are requests that never finished within idempotencyLease.
+// It must run after DeserializeUser, keys are per user.
+func (m *Middleware) Idempotency(c *fiber.Ctx) error {
+	key := c.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return c.Next()
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return sendErrorResponse(c, fiber.StatusBadRequest, "Idempotency-Key is too long")
+	}
+	user, ok := c.Locals("user").(models.UserResponse)
+	if !ok {
+		return sendErrorResponse(c, fiber.StatusUnauthorized, "You are not logged in")
+	}
+
+	record := &models.IdempotencyKey{Key: key, UserID: user.ID, RequestHash: requestHash(c)}
+	for attempt := 0; ; attempt++ {
+		if attempt == maxIdempotencyClaims {
+			return sendErrorResponse(c, fiber.StatusConflict, "A request with this Idempotency-Key is still in progress")
+		}
+		claimed, err := models.ClaimIdempotencyKey(m.db, record)
+		if err != nil {
+			return sendErrorResponse(c, fiber.StatusInternalServerError, "Failed to store Idempotency-Key")
+		}
+		if claimed {
+			break
+		}
+
+		existing, err := models.FindIdempotencyKey(m.db, user.ID, key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// released or expired meanwhile, claim it again
+			continue
+		}
+		if err != nil {
+			return sendErrorResponse(c, fiber.StatusInternalServerError, "Failed to read Idempotency-Key")
+		}
+		if time.Since(existing.CreatedAt) > m.idempotencyWindow() {
+			if err := existing.Release(m.db); err != nil {
+				return sendErrorResponse(c, fiber.StatusInternalServerError, "Failed to expire Idempotency-Key")
+			}
+			continue
+		}
+		if existing.RequestHash != record.RequestHash {
+			return sendErrorResponse(c, fiber.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		}
+		if existing.StatusCode == 0 {
+			if time.Since(existing.CreatedAt) <= idempotencyLease {
+				return sendErrorResponse(c, fiber.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			}
+			if err := existing.Release(m.db); err != nil {
+				return sendErrorResponse(c, fiber.StatusInternalServerError, "Failed to expire Idempotency-Key")
+			}
+			continue
+		}
+		c.Set(IdempotentReplayedHeader, "true")
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Status(existing.StatusCode).Send(existing.Response)
+	}
+
+	err := c.Next()
+	status := c.Response().StatusCode()
+	if err != nil || status >= fiber.StatusInternalServerError {
+		if releaseErr := record.Release(m.db); releaseErr != nil {
+			log.Printf("failed to release Idempotency-Key %s: %v", key, releaseErr)
+		}
+		return err
+	}
+	// the body belongs to fasthttp and is reused after the request
+	response := append([]byte(nil), c.Response().Body()...)
+	if err := record.Complete(m.db, status, response); err != nil {
+		log.Printf("failed to store response of Idempotency-Key %s: %v", key, err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes expired keys every interval until ctx is done
+func (m *Middleware) PurgeIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := models.DeleteIdempotencyKeysBefore(m.db, time.Now().Add(-m.idempotencyWindow()))
+			if err != nil {
+				log.Printf("failed to purge idempotency keys: %v", err)
+			} else if deleted > 0 {
+				log.Printf("purged %d expired idempotency keys", deleted)
+			}
+		}
+	}
+}
+
+func (m *Middleware) idempotencyWindow() time.Duration {
+	if m.config == nil || m.config.IdempotencyWindow <= 0 {
+		return DefaultIdempotencyWindow
+	}
+	return m.config.IdempotencyWindow
+}
+
+// requestHash identifies a request by its method, URL and body
+func requestHash(c *fiber.Ctx) string {
+	return hashRequest(c.Method(), c.OriginalURL(), c.Body())
+}
+
+func hashRequest(method string, url string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(url))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
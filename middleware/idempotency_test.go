@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cploutarchou/go-kafka-rest/initializers"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestIdempotency(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	m := NewMiddleware(&initializers.Config{IdempotencyWindow: time.Hour}, gormDB)
+	userID := uuid.New()
+	handled := 0
+	app := fiber.New()
+	app.Post("/send", func(c *fiber.Ctx) error {
+		c.Locals("user", models.UserResponse{ID: userID})
+		return c.Next()
+	}, m.Idempotency, func(c *fiber.Ctx) error {
+		handled++
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success"})
+	})
+	send := func(body string) (int, string, string) {
+		req := httptest.NewRequest(fiber.MethodPost, "/send", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "retry-1")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data), resp.Header.Get(IdempotentReplayedHeader)
+	}
+	taken := func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO "idempotency_keys" (.+) ON CONFLICT DO NOTHING`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+	}
+	stored := func(body string, response string) {
+		mock.ExpectQuery(`SELECT \* FROM "idempotency_keys"`).WillReturnRows(
+			sqlmock.NewRows([]string{"key", "user_id", "request_hash", "status_code", "response", "created_at"}).
+				AddRow("retry-1", userID, hashRequest(fiber.MethodPost, "/send", []byte(body)), fiber.StatusOK, []byte(response), time.Now()))
+	}
+	claimed := func(body string, at time.Time) {
+		mock.ExpectQuery(`SELECT \* FROM "idempotency_keys"`).WillReturnRows(
+			sqlmock.NewRows([]string{"key", "user_id", "request_hash", "claim", "status_code", "created_at"}).
+				AddRow("retry-1", userID, hashRequest(fiber.MethodPost, "/send", []byte(body)), "abandoned", 0, at))
+	}
+
+	// the first request claims the key, is handled and its response stored
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "idempotency_keys" (.+) ON CONFLICT DO NOTHING`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "idempotency_keys"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	status, first, replayed := send(`{"topic":"orders"}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Empty(t, replayed)
+	assert.Equal(t, 1, handled)
+
+	// a retry gets the stored response back
+	taken()
+	stored(`{"topic":"orders"}`, first)
+	status, body, replayed := send(`{"topic":"orders"}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, first, body)
+	assert.Equal(t, "true", replayed)
+	assert.Equal(t, 1, handled, "a retry should not be handled again")
+
+	// the key can't be reused for another request
+	taken()
+	stored(`{"topic":"orders"}`, first)
+	status, _, _ = send(`{"topic":"payments"}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+	assert.Equal(t, 1, handled)
+
+	// a request that is still being handled holds the key
+	taken()
+	claimed(`{"topic":"orders"}`, time.Now())
+	status, _, _ = send(`{"topic":"orders"}`)
+	assert.Equal(t, fiber.StatusConflict, status)
+	assert.Equal(t, 1, handled)
+
+	// a request that never finished within the lease gives the key up, only
+	// its own claim is deleted so a claim made meanwhile is kept
+	taken()
+	claimed(`{"topic":"orders"}`, time.Now().Add(-idempotencyLease-time.Minute))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "idempotency_keys" WHERE key = \$1 AND user_id = \$2 AND claim = \$3`).
+		WithArgs("retry-1", userID, "abandoned").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "idempotency_keys" (.+) ON CONFLICT DO NOTHING`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "idempotency_keys" SET (.+) WHERE key = \$\d+ AND user_id = \$\d+ AND claim = \$\d+`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	status, _, _ = send(`{"topic":"orders"}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, 2, handled)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireRole lets only users with one of the roles through, it runs after DeserializeUser
+func (m *Middleware) RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.UserResponse)
+		if !ok {
+			return sendErrorResponse(c, fiber.StatusUnauthorized, "You are not logged in")
+		}
+		for _, role := range roles {
+			if user.Role == role {
+				return c.Next()
+			}
+		}
+		return sendErrorResponse(c, fiber.StatusForbidden, "You are not allowed to do this")
+	}
+}
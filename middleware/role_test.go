@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	m := &Middleware{}
+	app := fiber.New()
+	app.Get("/topics", func(c *fiber.Ctx) error {
+		if role := c.Get("X-Role"); role != "" {
+			c.Locals("user", models.UserResponse{Role: role})
+		}
+		return c.Next()
+	}, m.RequireRole(models.RoleAdmin), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for role, status := range map[string]int{
+		models.RoleAdmin: fiber.StatusOK,
+		models.RoleUser:  fiber.StatusForbidden,
+		"":               fiber.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(fiber.MethodGet, "/topics", nil)
+		req.Header.Set("X-Role", role)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode, "role %q", role)
+	}
+}
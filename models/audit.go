@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Audited cluster changes
+const (
+	AuditCreateTopic      = "create_topic"
+	AuditDeleteTopic      = "delete_topic"
+	AuditAlterTopicConfig = "alter_topic_config"
+	AuditCreatePartitions = "create_partitions"
+	AuditResetOffsets     = "reset_offsets"
+)
+
+// AuditEntry records a change made to the cluster through the API and who made it
+type AuditEntry struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	UserEmail string    `gorm:"type:varchar(100);not null"`
+	Action    string    `gorm:"type:varchar(50);not null;index"`
+	// Resource names what was changed, such as the topic
+	Resource string `gorm:"type:varchar(255);not null;index"`
+	// Details holds the change, typically the values before and after
+	Details   map[string]interface{} `gorm:"type:jsonb;serializer:json"`
+	CreatedAt time.Time              `gorm:"autoCreateTime;index"`
+}
+
+// AuditFilter narrows down a listing of audit entries
+type AuditFilter struct {
+	Action   string
+	Resource string
+}
+
+// CreateAuditEntry stores the audit entry
+func CreateAuditEntry(db *gorm.DB, entry *AuditEntry) error {
+	return db.Create(entry).Error
+}
+
+// ListAuditEntries returns a page of audit entries, newest first, and how many match the filter
+func ListAuditEntries(db *gorm.DB, filter AuditFilter, limit int, offset int) ([]AuditEntry, int64, error) {
+	query := db.Model(&AuditEntry{})
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Resource != "" {
+		query = query.Where("resource = ?", filter.Resource)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []AuditEntry
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&entries).Error
+	return entries, total, err
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DeadLetter is an async message that could not be produced after its last
+// attempt. Every dead letter is stored here, PublishedTo names the dead
+// letter topic it was also written to, if Kafka took it.
+type DeadLetter struct {
+	ID uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	// MessageID is the id the message was accepted with, if any
+	MessageID string `gorm:"type:varchar(255);index"`
+	Topic     string `gorm:"type:varchar(255);not null;index"`
+	// Partition is the partition of the last attempt, -1 if none was picked
+	Partition int32
+	// RequestedPartition is the partition the producer asked for, if any
+	RequestedPartition *int32
+	Key                []byte            `gorm:"type:bytea"`
+	Value              []byte            `gorm:"type:bytea"`
+	Headers            map[string]string `gorm:"type:jsonb;serializer:json"`
+	Error              string            `gorm:"type:text;not null"`
+	Attempts           int               `gorm:"not null"`
+	FirstAttemptAt     time.Time         `gorm:"not null"`
+	LastAttemptAt      time.Time         `gorm:"not null"`
+	PublishedTo        string            `gorm:"type:varchar(255)"`
+	RedrivenAt         *time.Time        `gorm:"index"`
+	CreatedAt          time.Time         `gorm:"autoCreateTime;index"`
+}
+
+// DeadLetterFilter narrows down a listing of dead letters
+type DeadLetterFilter struct {
+	Topic string
+	// Pending leaves out the dead letters already re-driven
+	Pending bool
+}
+
+// CreateDeadLetter stores the dead letter
+func CreateDeadLetter(db *gorm.DB, deadLetter *DeadLetter) error {
+	return db.Create(deadLetter).Error
+}
+
+// ListDeadLetters returns a page of dead letters, newest first, and how many match the filter
+func ListDeadLetters(db *gorm.DB, filter DeadLetterFilter, limit int, offset int) ([]DeadLetter, int64, error) {
+	query := db.Model(&DeadLetter{})
+	if filter.Topic != "" {
+		query = query.Where("topic = ?", filter.Topic)
+	}
+	if filter.Pending {
+		query = query.Where("redriven_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var deadLetters []DeadLetter
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&deadLetters).Error
+	return deadLetters, total, err
+}
+
+// FindDeadLetter returns the dead letter with the id
+func FindDeadLetter(db *gorm.DB, id uuid.UUID) (*DeadLetter, error) {
+	var deadLetter DeadLetter
+	if err := db.Where("id = ?", id).First(&deadLetter).Error; err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+
+// FindDeadLetterByMessageID returns the dead letter of the message accepted with the id
+func FindDeadLetterByMessageID(db *gorm.DB, messageID string) (*DeadLetter, error) {
+	var deadLetter DeadLetter
+	if err := db.Where("message_id = ?", messageID).Order("created_at DESC").First(&deadLetter).Error; err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+
+// ClaimRedrive marks the dead letter re-driven unless it already is, so only
+// one of concurrent re-drives produces it. It reports whether the claim was won.
+func (d *DeadLetter) ClaimRedrive(db *gorm.DB, at time.Time) (bool, error) {
+	result := db.Model(&DeadLetter{}).Where("id = ? AND redriven_at IS NULL", d.ID).Update("redriven_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+	d.RedrivenAt = &at
+	return true, nil
+}
+
+// ReleaseRedrive gives up the claim of a re-drive that failed
+func (d *DeadLetter) ReleaseRedrive(db *gorm.DB) error {
+	if d.RedrivenAt == nil {
+		return nil
+	}
+	err := db.Model(&DeadLetter{}).Where("id = ? AND redriven_at = ?", d.ID, *d.RedrivenAt).Update("redriven_at", nil).Error
+	if err == nil {
+		d.RedrivenAt = nil
+	}
+	return err
+}
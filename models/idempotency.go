@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyKey remembers the response to a request sent with an
+// Idempotency-Key header, so retries of the request get the same response
+type IdempotencyKey struct {
+	Key         string    `gorm:"type:varchar(255);primaryKey"`
+	UserID      uuid.UUID `gorm:"type:uuid;primaryKey"`
+	RequestHash string    `gorm:"type:varchar(64);not null"`
+	// Claim identifies the request holding the key, a request only completes
+	// or releases its own claim
+	Claim string `gorm:"type:varchar(36);not null;default:''"`
+	// StatusCode is 0 while the first request is still being handled
+	StatusCode int       `gorm:"not null;default:0"`
+	Response   []byte    `gorm:"type:bytea"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index"`
+}
+
+// ErrIdempotencyKeyLost reports a claim that was released or taken over
+var ErrIdempotencyKeyLost = errors.New("idempotency key is no longer claimed by this request")
+
+// ClaimIdempotencyKey stores the key with a new claim unless the user
+// already used it, and reports whether it did
+func ClaimIdempotencyKey(db *gorm.DB, key *IdempotencyKey) (bool, error) {
+	key.Claim, key.CreatedAt = uuid.NewString(), time.Now()
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// FindIdempotencyKey returns the key of the user
+func FindIdempotencyKey(db *gorm.DB, userID uuid.UUID, key string) (*IdempotencyKey, error) {
+	var idempotencyKey IdempotencyKey
+	if err := db.Where("key = ? AND user_id = ?", key, userID).First(&idempotencyKey).Error; err != nil {
+		return nil, err
+	}
+	return &idempotencyKey, nil
+}
+
+// Complete stores the response to replay for the key, as long as the claim still holds it
+func (k *IdempotencyKey) Complete(db *gorm.DB, statusCode int, response []byte) error {
+	k.StatusCode, k.Response = statusCode, response
+	result := db.Model(&IdempotencyKey{}).
+		Where("key = ? AND user_id = ? AND claim = ?", k.Key, k.UserID, k.Claim).
+		Updates(map[string]interface{}{"status_code": statusCode, "response": response})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// Release deletes the key if it still has this claim, so the request may be
+// sent again with it. A claim made meanwhile by another request is kept.
+func (k *IdempotencyKey) Release(db *gorm.DB) error {
+	return db.Where("key = ? AND user_id = ? AND claim = ?", k.Key, k.UserID, k.Claim).Delete(&IdempotencyKey{}).Error
+}
+
+// DeleteIdempotencyKeysBefore deletes the keys created before the time
+func DeleteIdempotencyKeysBefore(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("created_at < ?", before).Delete(&IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
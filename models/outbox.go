@@ -0,0 +1,92 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outbox message states
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+)
+
+// OutboxMessage is a record accepted by the API that waits in the outbox
+// until the relay has written it to Kafka
+type OutboxMessage struct {
+	ID        uuid.UUID         `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	Topic     string            `gorm:"type:varchar(255);not null"`
+	Key       []byte            `gorm:"type:bytea"`
+	Value     []byte            `gorm:"type:bytea;not null"`
+	Headers   map[string]string `gorm:"type:jsonb;serializer:json"`
+	Partition *int32
+	Status    string `gorm:"type:varchar(20);not null;default:'pending';index:idx_outbox_due,priority:1"`
+	Attempts  int    `gorm:"not null;default:0"`
+	LastError string `gorm:"type:text"`
+	// NextAttemptAt holds failed messages back until their next attempt
+	NextAttemptAt time.Time `gorm:"not null;index:idx_outbox_due,priority:2"`
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	SentAt        *time.Time
+	// SentPartition and SentOffset tell where the message was written
+	SentPartition *int32
+	SentOffset    *int64
+}
+
+// EnqueueOutboxMessage stores the message for the relay
+func EnqueueOutboxMessage(db *gorm.DB, message *OutboxMessage) error {
+	message.Status = OutboxPending
+	if message.NextAttemptAt.IsZero() {
+		message.NextAttemptAt = time.Now()
+	}
+	return db.Create(message).Error
+}
+
+// LockDueOutboxMessages returns the oldest pending messages due at now and
+// locks them until tx ends. Rows locked by other relays are skipped.
+func LockDueOutboxMessages(tx *gorm.DB, now time.Time, limit int) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", OutboxPending, now).
+		Order("created_at").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// FindOutboxMessage returns the message with the id
+func FindOutboxMessage(db *gorm.DB, id uuid.UUID) (*OutboxMessage, error) {
+	var message OutboxMessage
+	if err := db.Where("id = ?", id).First(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// MarkSent records that the message was written to Kafka at the partition and offset
+func (m *OutboxMessage) MarkSent(tx *gorm.DB, at time.Time, partition int32, offset int64) error {
+	m.Status, m.SentAt, m.SentPartition, m.SentOffset = OutboxSent, &at, &partition, &offset
+	m.Attempts++
+	return tx.Model(m).Updates(map[string]interface{}{
+		"status":         m.Status,
+		"sent_at":        at,
+		"sent_partition": partition,
+		"sent_offset":    offset,
+		"attempts":       m.Attempts,
+	}).Error
+}
+
+// MarkFailed records a failed attempt and when to try again
+func (m *OutboxMessage) MarkFailed(tx *gorm.DB, reason string, next time.Time) error {
+	m.LastError, m.NextAttemptAt = reason, next
+	m.Attempts++
+	return tx.Model(m).Updates(map[string]interface{}{"last_error": reason, "next_attempt_at": next, "attempts": m.Attempts}).Error
+}
+
+// DeleteSentOutboxMessagesBefore deletes the messages sent before the time
+func DeleteSentOutboxMessagesBefore(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("status = ? AND sent_at < ?", OutboxSent, before).Delete(&OutboxMessage{})
+	return result.RowsAffected, result.Error
+}
@@ -8,6 +8,12 @@ import (
 	"time"
 )
 
+// User roles, admins may manage the cluster
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User holds user related properties
 type User struct {
 	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
@@ -0,0 +1,179 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/cploutarchou/go-kafka-rest/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Relay defaults, used for the options left at zero
+const (
+	DefaultInterval   = time.Second
+	DefaultBatchSize  = 500
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 5 * time.Minute
+	DefaultRetention  = 7 * 24 * time.Hour
+)
+
+// Producer is the part of kafka.Producer the relay needs
+type Producer interface {
+	SendBatch(messages []kafka.Message) []kafka.BatchResult
+}
+
+type Options struct {
+	// Interval is how often the outbox is polled when it was found empty
+	Interval time.Duration
+	// BatchSize is the most messages relayed in one transaction
+	BatchSize int
+	// MinBackoff and MaxBackoff bound the wait before a failed message is retried, it doubles on every attempt
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Retention is how long sent messages are kept
+	Retention time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = DefaultMinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = DefaultMaxBackoff
+		if o.MaxBackoff < o.MinBackoff {
+			o.MaxBackoff = o.MinBackoff
+		}
+	}
+	if o.Retention <= 0 {
+		o.Retention = DefaultRetention
+	}
+	return o
+}
+
+// Enqueue stores the message in the outbox and returns its id, the id of the
+// message when it is a UUID. Once stored the message is delivered at least
+// once, even if Kafka is down right now.
+func Enqueue(db *gorm.DB, message kafka.Message) (uuid.UUID, error) {
+	outboxMessage := &models.OutboxMessage{
+		Topic:     message.Topic,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   message.Headers,
+		Partition: message.Partition,
+	}
+	if id, err := uuid.Parse(message.ID); err == nil {
+		// keep the id the message was accepted with
+		outboxMessage.ID = id
+	}
+	if err := models.EnqueueOutboxMessage(db, outboxMessage); err != nil {
+		return uuid.Nil, err
+	}
+	return outboxMessage.ID, nil
+}
+
+// Relay writes the messages of the outbox to Kafka. Several relays, in this
+// or other instances, may share an outbox: each message is locked by the
+// relay sending it.
+type Relay struct {
+	db       *gorm.DB
+	producer Producer
+	options  Options
+	Logger   *log.Logger
+}
+
+func NewRelay(db *gorm.DB, producer Producer, options Options) *Relay {
+	return &Relay{
+		db:       db,
+		producer: producer,
+		options:  options.withDefaults(),
+		Logger:   log.New(os.Stdout, "outbox: ", log.LstdFlags),
+	}
+}
+
+// Run relays messages until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	for {
+		relayed, err := r.RelayOnce()
+		if err != nil {
+			r.Logger.Printf("Failed to relay outbox messages: %v", err)
+		}
+
+		wait := r.options.Interval
+		if err == nil && relayed == r.options.BatchSize {
+			// more messages are probably waiting
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-purge.C:
+			deleted, err := models.DeleteSentOutboxMessagesBefore(r.db, time.Now().Add(-r.options.Retention))
+			if err != nil {
+				r.Logger.Printf("Failed to purge sent outbox messages: %v", err)
+			} else if deleted > 0 {
+				r.Logger.Printf("Purged %d sent outbox messages", deleted)
+			}
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayOnce sends one batch of due messages and returns how many it handled
+func (r *Relay) RelayOnce() (int, error) {
+	relayed := 0
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		due, err := models.LockDueOutboxMessages(tx, now, r.options.BatchSize)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		relayed = len(due)
+
+		messages := make([]kafka.Message, len(due))
+		for i, message := range due {
+			messages[i] = kafka.Message{
+				ID:        message.ID.String(),
+				Topic:     message.Topic,
+				Key:       message.Key,
+				Value:     message.Value,
+				Headers:   message.Headers,
+				Partition: message.Partition,
+			}
+		}
+
+		for i, result := range r.producer.SendBatch(messages) {
+			message := &due[i]
+			if result.Err == nil {
+				err = message.MarkSent(tx, now, result.Metadata.Partition, result.Metadata.Offset)
+			} else {
+				next := now.Add(r.retryPolicy().Backoff(message.Attempts + 1))
+				r.Logger.Printf("Failed to relay message %s to topic %s, attempt %d: %v", message.ID, message.Topic, message.Attempts+1, result.Err)
+				err = message.MarkFailed(tx, result.Err.Error(), next)
+			}
+			if err != nil {
+				// rolling back leaves the messages pending, sent ones are sent again
+				return err
+			}
+		}
+		return nil
+	})
+	return relayed, err
+}
+
+// retryPolicy gives the backoff of failed messages, they are retried until sent
+func (r *Relay) retryPolicy() kafka.RetryPolicy {
+	return kafka.RetryPolicy{MinBackoff: r.options.MinBackoff, MaxBackoff: r.options.MaxBackoff}
+}
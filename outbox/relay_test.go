@@ -0,0 +1,60 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Shopify/sarama"
+	"github.com/cploutarchou/go-kafka-rest/kafka"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type batchProducer struct {
+	sent    []kafka.Message
+	results []kafka.BatchResult
+}
+
+func (p *batchProducer) SendBatch(messages []kafka.Message) []kafka.BatchResult {
+	p.sent = append(p.sent, messages...)
+	return p.results
+}
+
+func TestRelayOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	assert.NoError(t, err)
+
+	producer := &batchProducer{results: []kafka.BatchResult{
+		{Metadata: kafka.RecordMetadata{Topic: "orders", Offset: 7}},
+		{Err: sarama.ErrNotEnoughReplicas},
+	}}
+	relay := NewRelay(gormDB, producer, Options{BatchSize: 10})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "outbox_messages" WHERE (.+) ORDER BY created_at LIMIT 10 FOR UPDATE SKIP LOCKED`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "topic", "key", "value", "headers", "status", "attempts", "next_attempt_at", "created_at"}).
+			AddRow(uuid.New(), "orders", []byte("42"), []byte("created"), `{"tenant":"acme"}`, "pending", 0, time.Now(), time.Now()).
+			AddRow(uuid.New(), "payments", nil, []byte("charged"), nil, "pending", 2, time.Now(), time.Now()))
+	mock.ExpectExec(`UPDATE "outbox_messages" SET "attempts"=\$1,"sent_at"=\$2,"sent_offset"=\$3,"sent_partition"=\$4,"status"=\$5`).
+		WithArgs(1, sqlmock.AnyArg(), 7, 0, "sent", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "outbox_messages" SET "attempts"=\$1,"last_error"=\$2,"next_attempt_at"=\$3`).
+		WithArgs(3, sarama.ErrNotEnoughReplicas.Error(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	relayed, err := relay.RelayOnce()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, relayed)
+	assert.Len(t, producer.sent, 2)
+	assert.Equal(t, []byte("42"), producer.sent[0].Key)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, producer.sent[0].Headers)
+	assert.Nil(t, producer.sent[1].Key)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
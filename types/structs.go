@@ -1,8 +1,315 @@
 package types
 
-// MessagePayload holds data related to a message payload
+import (
+	"encoding/json"
+	"time"
+)
+
+// MessagePayload holds data related to a message payload. Key and Data are
+// embedded in the format named by KeyFormat and ValueFormat: string (the
+// default), binary for base64 or json for any JSON value.
 type MessagePayload struct {
-	Topic string `json:"topic" validate:"required"`
-	Data  string `json:"data" validate:"required"`
-	Key   string `json:"key" validate:"required"`
+	Topic       string            `json:"topic" validate:"required"`
+	Data        json.RawMessage   `json:"data" validate:"required"`
+	Key         json.RawMessage   `json:"key" validate:"required"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Partition   *int32            `json:"partition,omitempty" validate:"omitempty,min=0"`
+	KeyFormat   string            `json:"key_format,omitempty" validate:"omitempty,oneof=string binary json"`
+	ValueFormat string            `json:"value_format,omitempty" validate:"omitempty,oneof=string binary json"`
+}
+
+// ProduceResult tells where a produced record was written
+type ProduceResult struct {
+	ID        string    `json:"id,omitempty"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// BatchPayload holds the records of a batch produce, each is checked on its own
+type BatchPayload struct {
+	Records []MessagePayload `json:"records" validate:"required,min=1,max=10000"`
+}
+
+// BatchRecordResult is the outcome of one record of a batch, either where it
+// was written or why it was not
+type BatchRecordResult struct {
+	// ID is set on the records accepted
+	ID        string     `json:"id,omitempty"`
+	Topic     string     `json:"topic"`
+	Status    int        `json:"status"`
+	Partition *int32     `json:"partition,omitempty"`
+	Offset    *int64     `json:"offset,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// CreateConsumerPayload holds the options of a new consumer instance
+type CreateConsumerPayload struct {
+	AutoOffsetReset string `json:"auto_offset_reset" validate:"omitempty,oneof=earliest latest"`
+	AutoCommit      *bool  `json:"auto_commit"`
+}
+
+// SubscriptionPayload holds the topics a consumer instance subscribes to
+type SubscriptionPayload struct {
+	Topics []string `json:"topics" validate:"required,min=1,dive,required"`
+}
+
+// ConsumerRecord holds a record fetched by a consumer instance, Key and Value
+// are embedded in the format asked for by the caller
+type ConsumerRecord struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       json.RawMessage   `json:"key"`
+	Value     json.RawMessage   `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// TopicPartition addresses a single partition of a topic
+type TopicPartition struct {
+	Topic     string `json:"topic" validate:"required"`
+	Partition int32  `json:"partition" validate:"min=0"`
+}
+
+// PartitionOffset holds an offset of a single partition
+type PartitionOffset struct {
+	Topic     string `json:"topic" validate:"required"`
+	Partition int32  `json:"partition" validate:"min=0"`
+	Offset    int64  `json:"offset" validate:"min=0"`
+}
+
+// OffsetsPayload holds the offsets to commit or seek to
+type OffsetsPayload struct {
+	Offsets []PartitionOffset `json:"offsets" validate:"dive"`
+}
+
+// PartitionsPayload holds the partitions to seek, all assigned partitions when empty
+type PartitionsPayload struct {
+	Partitions []TopicPartition `json:"partitions" validate:"dive"`
+}
+
+// SeekTimestampPayload holds the timestamp to seek the partitions to
+type SeekTimestampPayload struct {
+	Timestamp  time.Time        `json:"timestamp" validate:"required"`
+	Partitions []TopicPartition `json:"partitions" validate:"dive"`
+}
+
+// AutoCommitPayload turns auto-commit of a consumer instance on or off
+type AutoCommitPayload struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+}
+
+// DeadLetter is an async record the producer gave up on, Key and Value are
+// embedded in the format asked for by the caller
+type DeadLetter struct {
+	ID             string            `json:"id"`
+	Topic          string            `json:"topic"`
+	Partition      int32             `json:"partition"`
+	Key            json.RawMessage   `json:"key"`
+	Value          json.RawMessage   `json:"value"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Error          string            `json:"error"`
+	Attempts       int               `json:"attempts"`
+	FirstAttemptAt time.Time         `json:"first_attempt_at"`
+	LastAttemptAt  time.Time         `json:"last_attempt_at"`
+	PublishedTo    string            `json:"published_to,omitempty"`
+	RedrivenAt     *time.Time        `json:"redriven_at,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// MessageStatus tells what became of an accepted message: pending, delivered
+// with where it was written, or failed with why
+type MessageStatus struct {
+	ID        string     `json:"id"`
+	Topic     string     `json:"topic"`
+	Status    string     `json:"status"`
+	Partition *int32     `json:"partition,omitempty"`
+	Offset    *int64     `json:"offset,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Attempts  int        `json:"attempts"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// CreateTopicPayload holds a topic to create, partitions and replication
+// factor default to the gateway's settings
+type CreateTopicPayload struct {
+	Name              string            `json:"name" validate:"required,max=249"`
+	Partitions        int32             `json:"partitions,omitempty" validate:"omitempty,min=1"`
+	ReplicationFactor int16             `json:"replication_factor,omitempty" validate:"omitempty,min=1"`
+	Configs           map[string]string `json:"configs,omitempty"`
+}
+
+type Topic struct {
+	Name              string            `json:"name"`
+	Partitions        int32             `json:"partitions"`
+	ReplicationFactor int16             `json:"replication_factor"`
+	Configs           map[string]string `json:"configs,omitempty"`
+}
+
+// TopicDescription lists the partitions of a topic with their replicas by broker id
+type TopicDescription struct {
+	Name              string                 `json:"name"`
+	Internal          bool                   `json:"internal"`
+	ReplicationFactor int16                  `json:"replication_factor"`
+	Partitions        []PartitionDescription `json:"partitions"`
+}
+
+type PartitionDescription struct {
+	Partition       int32   `json:"partition"`
+	Leader          int32   `json:"leader"`
+	Replicas        []int32 `json:"replicas"`
+	ISR             []int32 `json:"isr"`
+	OfflineReplicas []int32 `json:"offline_replicas,omitempty"`
+}
+
+// TopicConfig is a config of a topic, Source tells whether the topic
+// overrides it or where the default comes from
+type TopicConfig struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	Default   bool   `json:"default"`
+	ReadOnly  bool   `json:"read_only"`
+	Sensitive bool   `json:"sensitive"`
+}
+
+// AlterTopicConfigPayload changes the configs it names only: Set overrides
+// configs of the topic and Delete reverts them to the defaults
+type AlterTopicConfigPayload struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Delete []string          `json:"delete,omitempty"`
+}
+
+// ConfigChange is a config before and after a change, a null New is the default
+type ConfigChange struct {
+	Name string  `json:"name"`
+	Old  string  `json:"old"`
+	New  *string `json:"new"`
+}
+
+// CreatePartitionsPayload holds the new partition count of a topic, it must be higher than the current one
+type CreatePartitionsPayload struct {
+	Count int32 `json:"count" validate:"required,min=1"`
+}
+
+type AuditEntry struct {
+	ID        string                 `json:"id"`
+	UserID    string                 `json:"user_id"`
+	UserEmail string                 `json:"user_email"`
+	Action    string                 `json:"action"`
+	Resource  string                 `json:"resource"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type ConsumerGroup struct {
+	Group        string `json:"group"`
+	ProtocolType string `json:"protocol_type"`
+}
+
+type GroupMember struct {
+	MemberID   string           `json:"member_id"`
+	InstanceID string           `json:"instance_id,omitempty"`
+	ClientID   string           `json:"client_id"`
+	ClientHost string           `json:"client_host"`
+	Assignment []TopicPartition `json:"assignment"`
+}
+
+// GroupDescription is the state of a consumer group and its members
+type GroupDescription struct {
+	Group        string        `json:"group"`
+	State        string        `json:"state"`
+	ProtocolType string        `json:"protocol_type"`
+	Protocol     string        `json:"protocol"`
+	Members      []GroupMember `json:"members"`
+}
+
+// PartitionLag is how many records a group has yet to read on a partition
+type PartitionLag struct {
+	Topic         string `json:"topic"`
+	Partition     int32  `json:"partition"`
+	Committed     int64  `json:"committed"`
+	HighWaterMark int64  `json:"high_water_mark"`
+	Lag           int64  `json:"lag"`
+	MemberID      string `json:"member_id,omitempty"`
+}
+
+// GroupLag is the total lag of a consumer group, with the lag of each
+// partition unless it is part of a summary
+type GroupLag struct {
+	Group      string         `json:"group"`
+	State      string         `json:"state"`
+	Lag        int64          `json:"lag"`
+	Partitions []PartitionLag `json:"partitions,omitempty"`
+}
+
+type TopicLag struct {
+	Group string `json:"group"`
+	Topic string `json:"topic"`
+	Lag   int64  `json:"lag"`
+}
+
+// OffsetResetPayload moves the committed offsets of a consumer group on the
+// partitions of Topics and on Partitions. Without Execute the reset is only
+// previewed, executing it takes the token of its preview.
+type OffsetResetPayload struct {
+	Mode       string           `json:"mode" validate:"required,oneof=to-earliest to-latest to-datetime shift-by to-explicit-offset"`
+	Topics     []string         `json:"topics,omitempty"`
+	Partitions []TopicPartition `json:"partitions,omitempty" validate:"dive"`
+	// Datetime is required by to-datetime, Shift by shift-by and Offset by to-explicit-offset
+	Datetime *time.Time `json:"datetime,omitempty"`
+	Shift    *int64     `json:"shift,omitempty"`
+	Offset   *int64     `json:"offset,omitempty" validate:"omitempty,min=0"`
+	Execute  bool       `json:"execute"`
+	Token    string     `json:"token,omitempty"`
+}
+
+// PartitionReset is the committed offset of a partition before and after a
+// reset, -1 when there was none
+type PartitionReset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Current   int64  `json:"current"`
+	Target    int64  `json:"target"`
+}
+
+type OffsetResetPlan struct {
+	Group         string           `json:"group"`
+	Mode          string           `json:"mode"`
+	Token         string           `json:"token"`
+	ActiveMembers int              `json:"active_members"`
+	Executed      bool             `json:"executed"`
+	Partitions    []PartitionReset `json:"partitions"`
+}
+
+// HealthCheck is the outcome of checking one dependency, up or down
+type HealthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Broker struct {
+	ID         int32  `json:"id"`
+	Addr       string `json:"addr"`
+	Rack       string `json:"rack,omitempty"`
+	Controller bool   `json:"controller"`
+}
+
+type PartitionState struct {
+	Topic string `json:"topic"`
+	PartitionDescription
+}
+
+// ClusterInfo lists the brokers of the cluster and the partitions that
+// lost replicas or their leader
+type ClusterInfo struct {
+	ControllerID    int32            `json:"controller_id"`
+	Brokers         []Broker         `json:"brokers"`
+	Topics          int              `json:"topics"`
+	Partitions      int              `json:"partitions"`
+	UnderReplicated []PartitionState `json:"under_replicated_partitions"`
+	Offline         []PartitionState `json:"offline_partitions"`
 }